@@ -0,0 +1,10 @@
+module github.com/hizumisen/go-rate-limiter/config
+
+go 1.21.6
+
+replace github.com/hizumisen/go-rate-limiter/core => ../core
+
+require (
+	github.com/hizumisen/go-rate-limiter/core v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v3 v3.0.1
+)
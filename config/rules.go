@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+)
+
+const (
+	TokenBucket          = "token_bucket"
+	GCRA                 = "gcra"
+	LeakyBucket          = "leaky_bucket"
+	SlidingWindowLog     = "sliding_window_log"
+	SlidingWindowCounter = "sliding_window_counter"
+	FixedWindow          = "fixed_window"
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+type Rule struct {
+	Pattern   string
+	Algorithm string
+	Config    core.AlgorithmConfig
+}
+
+type fileRule struct {
+	Pattern   string  `json:"pattern" yaml:"pattern"`
+	Algorithm string  `json:"algorithm" yaml:"algorithm"`
+	Burst     float64 `json:"burst" yaml:"burst"`
+	Rate      float64 `json:"rate" yaml:"rate"`
+	Window    string  `json:"window" yaml:"window"`
+}
+
+type file struct {
+	Rules []fileRule `json:"rules" yaml:"rules"`
+}
+
+// LoadRules reads the rules from a json file, or from a yaml file for the
+// other extensions
+func LoadRules(filePath string) ([]Rule, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't read config file: %w", err)
+	}
+
+	return parseRules(filePath, data)
+}
+
+func parseRules(filePath string, data []byte) ([]Rule, error) {
+	var content file
+	var err error
+	if filepath.Ext(filePath) == ".json" {
+		err = json.Unmarshal(data, &content)
+	} else {
+		err = yaml.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't parse config file %s: %w", filePath, err)
+	}
+
+	//an empty file is most likely being written, it must not remove every limit
+	if len(content.Rules) == 0 {
+		return nil, fmt.Errorf("can't load config file %s without rules:%w", filePath, ErrInvalidRule)
+	}
+
+	rules := make([]Rule, 0, len(content.Rules))
+	for i, fileRule := range content.Rules {
+		rule, err := parseRule(fileRule)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse rule %d: %w", i, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseRule(fileRule fileRule) (Rule, error) {
+	rule := Rule{
+		Pattern:   fileRule.Pattern,
+		Algorithm: fileRule.Algorithm,
+		Config: core.AlgorithmConfig{
+			Burst: fileRule.Burst,
+			Rate:  fileRule.Rate,
+		},
+	}
+
+	if fileRule.Window != "" {
+		window, err := time.ParseDuration(fileRule.Window)
+		if err != nil {
+			return rule, fmt.Errorf("can't parse window %s: %w", fileRule.Window, ErrInvalidRule)
+		}
+
+		rule.Config.Window = window
+	}
+
+	return rule, rule.validate()
+}
+
+func (rule Rule) validate() error {
+	_, err := path.Match(rule.Pattern, "")
+	if rule.Pattern == "" || err != nil {
+		return fmt.Errorf("bad pattern `%s`: %w", rule.Pattern, ErrInvalidRule)
+	}
+
+	if rule.Config.Burst <= 0 {
+		return fmt.Errorf("burst must be positive: %w", ErrInvalidRule)
+	}
+
+	switch rule.Algorithm {
+	case TokenBucket, GCRA, LeakyBucket:
+		if rule.Config.Rate <= 0 {
+			return fmt.Errorf("rate must be positive for %s: %w", rule.Algorithm, ErrInvalidRule)
+		}
+	case SlidingWindowLog, SlidingWindowCounter, FixedWindow:
+		if rule.Config.Window <= 0 {
+			return fmt.Errorf("window must be positive for %s: %w", rule.Algorithm, ErrInvalidRule)
+		}
+	default:
+		return fmt.Errorf("unknown algorithm `%s`: %w", rule.Algorithm, ErrInvalidRule)
+	}
+
+	return nil
+}
+
+func (rule Rule) matches(key string) bool {
+	matched, _ := path.Match(rule.Pattern, key)
+	return matched
+}
@@ -0,0 +1,91 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/config"
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func writeFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	err := os.WriteFile(filePath, []byte(content), 0o600)
+	testutils.RequireNoError(t, err)
+}
+
+func TestLoadRules_Yaml(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rules.yaml")
+	writeFile(t, filePath, `
+rules:
+  - pattern: "tenant-*"
+    algorithm: token_bucket
+    burst: 100
+    rate: 10
+  - pattern: "login/*"
+    algorithm: sliding_window_log
+    burst: 5
+    window: 15m
+`)
+
+	rules, err := config.LoadRules(filePath)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 2, len(rules))
+	testutils.RequireEqual(t, config.Rule{
+		Pattern:   "tenant-*",
+		Algorithm: config.TokenBucket,
+		Config:    core.AlgorithmConfig{Burst: 100, Rate: 10},
+	}, rules[0])
+	testutils.RequireEqual(t, config.Rule{
+		Pattern:   "login/*",
+		Algorithm: config.SlidingWindowLog,
+		Config:    core.AlgorithmConfig{Burst: 5, Window: 15 * time.Minute},
+	}, rules[1])
+}
+
+func TestLoadRules_Json(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rules.json")
+	writeFile(t, filePath, `{"rules": [{"pattern": "*", "algorithm": "gcra", "burst": 10, "rate": 1}]}`)
+
+	rules, err := config.LoadRules(filePath)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 1, len(rules))
+	testutils.RequireEqual(t, config.GCRA, rules[0].Algorithm)
+}
+
+func TestLoadRules_NoRules(t *testing.T) {
+	for _, content := range []string{"", "rules: []", "other: 1"} {
+		filePath := filepath.Join(t.TempDir(), "rules.yaml")
+		writeFile(t, filePath, content)
+
+		_, err := config.LoadRules(filePath)
+		if !errors.Is(err, config.ErrInvalidRule) {
+			t.Errorf("expected ErrInvalidRule for %q, got %v", content, err)
+		}
+	}
+}
+
+func TestLoadRules_Invalid(t *testing.T) {
+	contents := []string{
+		`{"rules": [{"pattern": "[", "algorithm": "gcra", "burst": 10, "rate": 1}]}`,
+		`{"rules": [{"pattern": "*", "algorithm": "unknown", "burst": 10, "rate": 1}]}`,
+		`{"rules": [{"pattern": "*", "algorithm": "gcra", "burst": 10}]}`,
+		`{"rules": [{"pattern": "*", "algorithm": "fixed_window", "burst": 10, "window": "1 minute"}]}`,
+		`{"rules": [{"pattern": "*", "algorithm": "fixed_window", "rate": 10, "window": "1m"}]}`,
+	}
+
+	for _, content := range contents {
+		filePath := filepath.Join(t.TempDir(), "rules.json")
+		writeFile(t, filePath, content)
+
+		_, err := config.LoadRules(filePath)
+		if !errors.Is(err, config.ErrInvalidRule) {
+			t.Errorf("expected ErrInvalidRule for %s, got %v", content, err)
+		}
+	}
+}
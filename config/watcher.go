@@ -0,0 +1,101 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+)
+
+type Watcher struct {
+	filePath string
+	rules    atomic.Pointer[[]Rule]
+	hash     [sha256.Size]byte
+}
+
+// NewWatcher loads the rules of the file, it fails if they are invalid
+func NewWatcher(filePath string) (*Watcher, error) {
+	watcher := &Watcher{
+		filePath: filePath,
+	}
+
+	_, err := watcher.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+func (w *Watcher) Rules() []Rule {
+	return *w.rules.Load()
+}
+
+// reloadIfChanged swaps in the rules only if they are all valid, the changes
+// are found by content as an edit can keep the size and the mtime
+func (w *Watcher) reloadIfChanged() (bool, error) {
+	data, err := os.ReadFile(w.filePath)
+	if err != nil {
+		return false, fmt.Errorf("can't read config file: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	if hash == w.hash {
+		return false, nil
+	}
+
+	//an invalid file is not loaded again until it changes
+	w.hash = hash
+
+	rules, err := parseRules(w.filePath, data)
+	if err != nil {
+		return false, err
+	}
+
+	w.rules.Store(&rules)
+
+	return true, nil
+}
+
+// Watch reloads the rules every interval until ctx is done, the current rules
+// are kept when the file is invalid
+func (w *Watcher) Watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := w.reloadIfChanged()
+			if err != nil {
+				logger.Warn("can't reload rate limit rules, keeping the current ones", "error", err)
+				continue
+			}
+
+			if reloaded {
+				logger.Info("reloaded rate limit rules", "rules", len(w.Rules()))
+			}
+		}
+	}
+}
+
+// PolicyFor resolves the config of the first rule of the algorithm whose
+// pattern matches the key, the pattern syntax is the one of path.Match
+func (w *Watcher) PolicyFor(algorithm string) core.PolicyResolver {
+	return func(ctx context.Context, key string) (core.AlgorithmConfig, error) {
+		for _, rule := range w.Rules() {
+			if rule.Algorithm == algorithm && rule.matches(key) {
+				return rule.Config, nil
+			}
+		}
+
+		return core.AlgorithmConfig{}, core.ErrNoPolicy
+	}
+}
@@ -0,0 +1,113 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/config"
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func requireBurst(t *testing.T, policy core.PolicyResolver, key string, burst float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		algConfig, err := policy(context.Background(), key)
+		testutils.RequireNoError(t, err)
+		if algConfig.Burst == burst || time.Now().After(deadline) {
+			testutils.RequireEqual(t, burst, algConfig.Burst)
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// replaceFile renames a complete file over filePath, so the watcher never
+// reads it half written
+func replaceFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	tempPath := filePath + ".tmp"
+	writeFile(t, tempPath, content)
+	testutils.RequireNoError(t, os.Rename(tempPath, filePath))
+}
+
+func TestWatcher_Watch_ReloadValidRulesOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filePath := filepath.Join(t.TempDir(), "rules.yaml")
+	writeFile(t, filePath, "rules: [{pattern: 'tenant-*', algorithm: token_bucket, burst: 10, rate: 1}]")
+
+	watcher, err := config.NewWatcher(filePath)
+	testutils.RequireNoError(t, err)
+	go watcher.Watch(ctx, testutils.NewNoOpLogger(), time.Millisecond)
+
+	policy := watcher.PolicyFor(config.TokenBucket)
+	requireBurst(t, policy, "tenant-1", 10)
+
+	replaceFile(t, filePath, "rules: [{pattern: 'tenant-*', algorithm: token_bucket, burst: 100, rate: 10}]")
+	requireBurst(t, policy, "tenant-1", 100)
+
+	//the invalid rules are never swapped in
+	replaceFile(t, filePath, "rules: [{pattern: 'tenant-*', algorithm: token_bucket, burst: 1000}]")
+	time.Sleep(50 * time.Millisecond)
+	requireBurst(t, policy, "tenant-1", 100)
+
+	replaceFile(t, filePath, "rules: []")
+	time.Sleep(50 * time.Millisecond)
+	requireBurst(t, policy, "tenant-1", 100)
+}
+
+func TestWatcher_Watch_ReloadSameSizeEdit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filePath := filepath.Join(t.TempDir(), "rules.yaml")
+	writeFile(t, filePath, "rules: [{pattern: 'tenant-*', algorithm: token_bucket, burst: 10, rate: 1}]")
+	info, err := os.Stat(filePath)
+	testutils.RequireNoError(t, err)
+
+	watcher, err := config.NewWatcher(filePath)
+	testutils.RequireNoError(t, err)
+	go watcher.Watch(ctx, testutils.NewNoOpLogger(), time.Millisecond)
+
+	policy := watcher.PolicyFor(config.TokenBucket)
+	requireBurst(t, policy, "tenant-1", 10)
+
+	//same size and same mtime, only the content tells the change
+	replaceFile(t, filePath, "rules: [{pattern: 'tenant-*', algorithm: token_bucket, burst: 20, rate: 1}]")
+	testutils.RequireNoError(t, os.Chtimes(filePath, info.ModTime(), info.ModTime()))
+	requireBurst(t, policy, "tenant-1", 20)
+}
+
+func TestWatcher_PolicyFor_NoMatchingRule(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rules.yaml")
+	writeFile(t, filePath, "rules: [{pattern: 'tenant-*', algorithm: gcra, burst: 10, rate: 1}]")
+
+	watcher, err := config.NewWatcher(filePath)
+	testutils.RequireNoError(t, err)
+
+	for _, key := range []string{"user-1", "tenant-1"} {
+		_, err = watcher.PolicyFor(config.TokenBucket)(context.Background(), key)
+		if !errors.Is(err, core.ErrNoPolicy) {
+			t.Errorf("expected ErrNoPolicy for %s, got %v", key, err)
+		}
+	}
+}
+
+func TestNewWatcher_InvalidFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rules.yaml")
+
+	_, err := config.NewWatcher(filePath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
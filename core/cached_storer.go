@@ -2,41 +2,212 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
+	"math"
 	"sync"
 	"time"
 )
 
 type cachedItem[T any] struct {
+	key        string
 	alg        T
 	sort       string
 	lastUsedAt time.Time
+	version    uint64 //incremented on every change
+	persisted  uint64 //the version known to be in the actual store
+	prev       *cachedItem[T]
+	next       *cachedItem[T]
 }
 
-func newCachedItem[T Algorithm](
+// dirty tells if the item has changes not written to the actual store yet
+func (item *cachedItem[T]) dirty() bool {
+	return item.version != item.persisted
+}
+
+// lruList links the cached items from the most to the least recently used
+type lruList[T any] struct {
+	head *cachedItem[T]
+	tail *cachedItem[T]
+}
+
+func (l *lruList[T]) pushFront(item *cachedItem[T]) {
+	item.prev = nil
+	item.next = l.head
+
+	if l.head != nil {
+		l.head.prev = item
+	} else {
+		l.tail = item
+	}
+
+	l.head = item
+}
+
+func (l *lruList[T]) remove(item *cachedItem[T]) {
+	if item.prev != nil {
+		item.prev.next = item.next
+	} else {
+		l.head = item.next
+	}
+
+	if item.next != nil {
+		item.next.prev = item.prev
+	} else {
+		l.tail = item.prev
+	}
+
+	item.prev = nil
+	item.next = nil
+}
+
+func (l *lruList[T]) moveToFront(item *cachedItem[T]) {
+	if l.head == item {
+		return
+	}
+
+	l.remove(item)
+	l.pushFront(item)
+}
+
+type cacheShard[T Algorithm] struct {
+	lock     sync.RWMutex
+	items    map[string]*cachedItem[T]
+	lru      lruList[T]
+	capacity int
+	leases   map[string]float64 //tokens leased from actualStore and not spent yet
+	pending  map[string]T       //dirty items out of the cache, written by the next flush
+}
+
+func newCacheShard[T Algorithm](capacity int) *cacheShard[T] {
+	return &cacheShard[T]{
+		items:    make(map[string]*cachedItem[T]),
+		capacity: capacity,
+		leases:   make(map[string]float64),
+		pending:  make(map[string]T),
+	}
+}
+
+func (shard *cacheShard[T]) delete(item *cachedItem[T]) {
+	shard.lru.remove(item)
+	delete(shard.items, item.key)
+
+	//the local changes are still written back
+	if item.dirty() && item.sort != "" {
+		shard.pending[item.key] = item.alg
+	}
+}
+
+func (shard *cacheShard[T]) removeExpired(isExpired func(item *cachedItem[T]) bool) {
+	for _, item := range shard.items {
+		if isExpired(item) {
+			shard.delete(item)
+		}
+	}
+}
+
+// set caches alg and returns the cached value, dirty tells if alg is a local
+// change that the actual store doesn't know yet
+func (shard *cacheShard[T]) set(
+	key string,
 	alg T,
 	lastUsedAt time.Time,
-) cachedItem[T] {
-	return cachedItem[T]{
+	dirty bool,
+	isExpired func(item *cachedItem[T]) bool,
+) T {
+	item, cached := shard.items[key]
+
+	local, hasLocal := shard.pending[key]
+	if cached && item.dirty() {
+		local, hasLocal = item.alg, true
+	}
+
+	if hasLocal && !dirty && local.SortValue() > alg.SortValue() {
+		//the local changes waiting for the flush are newer than the stored value
+		alg = local
+		dirty = true
+	}
+
+	if cached {
+		item.alg = alg
+		item.sort = alg.SortValue()
+		item.lastUsedAt = lastUsedAt
+		item.version++
+		if !dirty {
+			item.persisted = item.version
+		}
+		shard.lru.moveToFront(item)
+		return alg
+	}
+
+	if shard.capacity <= 0 {
+		return alg
+	}
+
+	delete(shard.pending, key)
+
+	//free some space in the cache, the least recently used items are at the
+	//tail and the expired ones go first
+	if len(shard.items) >= shard.capacity {
+		for shard.lru.tail != nil && isExpired(shard.lru.tail) {
+			shard.delete(shard.lru.tail)
+		}
+	}
+
+	if len(shard.items) >= shard.capacity {
+		shard.delete(shard.lru.tail)
+	}
+
+	item = &cachedItem[T]{
+		key:        key,
 		alg:        alg,
 		sort:       alg.SortValue(),
 		lastUsedAt: lastUsedAt,
+		version:    1,
+	}
+	if !dirty {
+		item.persisted = item.version
 	}
+
+	shard.items[key] = item
+	shard.lru.pushFront(item)
+
+	return alg
 }
 
+const (
+	maxCacheShards   = 16
+	minCacheShardLen = 64
+	flushBatchSize   = 100
+)
+
 type CachedStore[T Algorithm] struct {
-	internalCtx   context.Context
-	logger        *slog.Logger
-	actualStore   AlgorithmStorer[T]
-	cache         map[string]cachedItem[T]
-	lock          sync.RWMutex
-	cacheSize     int
-	cacheDuration time.Duration
-	cancel        func()
-	nowProvider   func() time.Time
+	internalCtx       context.Context
+	logger            *slog.Logger
+	actualStore       AlgorithmStorer[T]
+	shards            []*cacheShard[T]
+	cacheDuration     time.Duration
+	leaseFraction     float64
+	flushParallelism  int
+	flushErrorHandler func(key string, err error)
+	cancel            func()
+	closeOnce         sync.Once
+	closeErr          error
+	closeLock         sync.RWMutex
+	closed            bool
+	nowProvider       func() time.Time
 }
 
+var _ ReserveStorer[*TokenBucket] = &CachedStore[*TokenBucket]{}
+
+var errNothingToRefund = errors.New("nothing to refund")
+
+// ErrStoreClosed is returned by CachedStore.Reserve after Close, the leases
+// would never be given back
+var ErrStoreClosed = errors.New("store closed")
+
 func NewCachedStore[T Algorithm](
 	ctx context.Context,
 	logger *slog.Logger,
@@ -44,15 +215,28 @@ func NewCachedStore[T Algorithm](
 	cacheSize int,
 	cacheDuration time.Duration,
 ) *CachedStore[T] {
+	//small caches keep a single shard, for an exact lru eviction
+	shardCount := min(max(cacheSize/minCacheShardLen, 1), maxCacheShards)
+
+	shards := make([]*cacheShard[T], shardCount)
+	for i := range shards {
+		capacity := cacheSize / shardCount
+		if i < cacheSize%shardCount {
+			capacity++
+		}
+
+		shards[i] = newCacheShard[T](capacity)
+	}
+
 	store := &CachedStore[T]{
-		internalCtx:   ctx,
-		logger:        logger,
-		actualStore:   actualStore,
-		cache:         make(map[string]cachedItem[T]),
-		lock:          sync.RWMutex{},
-		cacheSize:     cacheSize,
-		cacheDuration: cacheDuration,
-		nowProvider:   time.Now,
+		internalCtx:      ctx,
+		logger:           logger,
+		actualStore:      actualStore,
+		shards:           shards,
+		cacheDuration:    cacheDuration,
+		leaseFraction:    0.1,
+		flushParallelism: 8,
+		nowProvider:      time.Now,
 	}
 
 	store.start()
@@ -60,26 +244,84 @@ func NewCachedStore[T Algorithm](
 	return store
 }
 
+// WithLeaseFraction sets the fraction of the burst of the algorithm that is
+// leased from the actual store at once
+func (store *CachedStore[T]) WithLeaseFraction(leaseFraction float64) *CachedStore[T] {
+	store.leaseFraction = leaseFraction
+	return store
+}
+
+// WithFlushParallelism sets how many calls to the actual store a flush makes
+// at a time
+func (store *CachedStore[T]) WithFlushParallelism(flushParallelism int) *CachedStore[T] {
+	store.flushParallelism = flushParallelism
+	return store
+}
+
+// WithFlushErrorHandler sets a function called with the keys that the periodic
+// flush can't write back, they are retried by the next flush
+func (store *CachedStore[T]) WithFlushErrorHandler(handler func(key string, err error)) *CachedStore[T] {
+	store.flushErrorHandler = handler
+	return store
+}
+
 func (store *CachedStore[T]) start() {
-	store.cancel = runPeriodically(store.cacheDuration, store.flushAndRefreshData)
+	ctx, cancel := context.WithCancel(store.internalCtx)
+
+	exited := runPeriodically(ctx, store.cacheDuration, func() {
+		err := store.flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			store.logger.Warn("can't persist and refresh cached data", "error", err)
+		}
+	})
+
+	store.cancel = func() {
+		cancel()
+		<-exited
+	}
 }
 
-func (store *CachedStore[T]) Stop() {
-	store.flushAndRefreshData()
-	if store != nil {
+// Close stops the periodic flush and writes back the cached data one last
+// time, within the deadline of ctx. It can be called more than once, the
+// following calls return the error of the first one.
+func (store *CachedStore[T]) Close(ctx context.Context) error {
+	store.closeOnce.Do(func() {
+		//wait for the reservations in flight, their leases are returned below
+		store.closeLock.Lock()
+		store.closed = true
+		store.closeLock.Unlock()
+
 		store.cancel()
+		store.closeErr = store.flush(ctx)
+	})
+
+	return store.closeErr
+}
+
+func (store *CachedStore[T]) Stop() {
+	if store == nil {
+		return
+	}
+
+	err := store.Close(context.Background())
+	if err != nil {
+		store.logger.Warn("can't persist cached data", "error", err)
 	}
 }
 
-func runPeriodically(duration time.Duration, fun func()) func() {
-	done := make(chan bool)
+// runPeriodically calls fun until ctx is done, the returned channel is closed
+// once it is stopped
+func runPeriodically(ctx context.Context, duration time.Duration, fun func()) <-chan struct{} {
+	exited := make(chan struct{})
 	ticker := time.NewTicker(duration)
 
 	go func() {
+		defer close(exited)
+		defer ticker.Stop()
+
 		for {
 			select {
-			case <-done:
-				ticker.Stop()
+			case <-ctx.Done():
 				return
 			case <-ticker.C:
 				fun()
@@ -87,124 +329,494 @@ func runPeriodically(duration time.Duration, fun func()) func() {
 		}
 	}()
 
-	cancelFun := func() {
-		done <- true
+	return exited
+}
+
+func (store *CachedStore[T]) shard(key string) *cacheShard[T] {
+	if len(store.shards) == 1 {
+		return store.shards[0]
 	}
 
-	return cancelFun
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return store.shards[hash.Sum32()%uint32(len(store.shards))]
 }
 
-func (store *CachedStore[T]) isExpiredFromCache(item cachedItem[T]) bool {
+func (store *CachedStore[T]) isExpiredFromCache(item *cachedItem[T]) bool {
 	return store.nowProvider().After(item.alg.ExpireAt()) ||
 		store.nowProvider().Sub(item.lastUsedAt) > store.cacheDuration
 }
 
-func (store *CachedStore[T]) flushAndRefreshData() {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// flush writes back the items changed since the last flush and refreshes the
+// others, the store calls are made without holding
+// the shard locks so that the requests are not blocked
+func (store *CachedStore[T]) flush(ctx context.Context) error {
+	leases := make(map[string]float64)
+	var dirty, clean []flushedItem[T]
+
+	for _, shard := range store.shards {
+		shardDirty, shardClean := store.snapshotShard(shard, leases)
+		dirty = append(dirty, shardDirty...)
+		clean = append(clean, shardClean...)
+	}
 
 	var errs []error
 
-	store.removeExpiredFromCache()
+	err := store.returnLeases(ctx, leases)
+	if err != nil {
+		errs = append(errs, err)
+	}
 
-	for key, val := range store.cache {
-		if val.sort != "" {
-			updated, err := store.actualStore.Store(store.internalCtx, key, val.alg)
-			if err != nil {
-				errs = append(errs, err)
-			}
+	errs = append(errs, store.storeDirty(ctx, dirty)...)
+	errs = append(errs, store.refreshClean(ctx, clean)...)
+
+	return errors.Join(errs...)
+}
+
+// flushFailed keeps what a flush couldn't write back for the next one, fun
+// runs with the lock of the shard of key
+func (store *CachedStore[T]) flushFailed(ctx context.Context, key string, err error, fun func(shard *cacheShard[T])) {
+	shard := store.shard(key)
+
+	shard.lock.Lock()
+	fun(shard)
+	shard.lock.Unlock()
+
+	//the errors of a cancelled flush are returned by Close
+	if store.flushErrorHandler != nil && ctx.Err() == nil {
+		store.flushErrorHandler(key, err)
+	}
+}
+
+type flushedItem[T Algorithm] struct {
+	shard   *cacheShard[T]
+	key     string
+	alg     T
+	version uint64
+}
+
+// snapshotShard removes the expired items and takes the leases, the items to
+// write back and the clean items to refresh, the leases are moved into leases
+func (store *CachedStore[T]) snapshotShard(
+	shard *cacheShard[T],
+	leases map[string]float64,
+) (dirty []flushedItem[T], clean []flushedItem[T]) {
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	shard.removeExpired(store.isExpiredFromCache)
+
+	for key, leased := range shard.leases {
+		leases[key] = leased
+	}
+	shard.leases = make(map[string]float64)
+
+	for key, item := range shard.items {
+		if item.sort == "" {
+			continue
+		}
 
-			store.cache[key] = newCachedItem(updated, val.lastUsedAt)
+		//the cached value keeps changing while the flush runs without the lock
+		flushed := flushedItem[T]{shard: shard, key: key, alg: cloneAlgorithm(item.alg), version: item.version}
+		if item.dirty() {
+			dirty = append(dirty, flushed)
+		} else {
+			clean = append(clean, flushed)
 		}
 	}
 
-	if len(errs) > 0 {
-		store.logger.Warn("can't persist and refresh cached data", "errors", errs)
+	for key, alg := range shard.pending {
+		if !store.nowProvider().After(alg.ExpireAt()) {
+			dirty = append(dirty, flushedItem[T]{shard: shard, key: key, alg: alg})
+		}
 	}
+	shard.pending = make(map[string]T)
+
+	return dirty, clean
 }
 
-func (store *CachedStore[T]) removeExpiredFromCache() bool {
-	removedSomething := false
+// runBatches splits items in batches of batchSize and calls fun with up to
+// flushParallelism batches at a time
+func (store *CachedStore[T]) runBatches(
+	items []flushedItem[T],
+	batchSize int,
+	fun func(batch []flushedItem[T]) error,
+) []error {
+	var batches [][]flushedItem[T]
+	for start := 0; start < len(items); start += batchSize {
+		batches = append(batches, items[start:min(start+batchSize, len(items))])
+	}
 
-	for key, val := range store.cache {
-		if store.isExpiredFromCache(val) {
-			delete(store.cache, key)
-			removedSomething = true
+	var lock sync.Mutex
+	var errs []error
+
+	runParallel(len(batches), store.flushParallelism, func(i int) {
+		err := fun(batches[i])
+		if err != nil {
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
 		}
+	})
+
+	return errs
+}
+
+// storeDirty writes the items in batches when the actual store is a
+// BatchStorer and one by one otherwise
+func (store *CachedStore[T]) storeDirty(ctx context.Context, items []flushedItem[T]) []error {
+	batchStorer, ok := store.actualStore.(BatchStorer[T])
+
+	batchSize := 1
+	if ok {
+		batchSize = flushBatchSize
 	}
 
-	return removedSomething
+	return store.runBatches(items, batchSize, func(batch []flushedItem[T]) error {
+		return store.storeBatch(ctx, batchStorer, batch)
+	})
 }
 
-func (store *CachedStore[T]) removeLastCached() {
-	var minKey string
+func (store *CachedStore[T]) storeBatch(ctx context.Context, batchStorer BatchStorer[T], batch []flushedItem[T]) error {
+	var updated map[string]T
+	var err error
+
+	if batchStorer != nil {
+		algs := make(map[string]T, len(batch))
+		for _, flushed := range batch {
+			algs[flushed.key] = flushed.alg
+		}
+
+		updated, err = batchStorer.StoreMany(ctx, algs)
+	} else {
+		flushed := batch[0]
 
-	for key := range store.cache {
-		minKey = key
-		break
+		var alg T
+		alg, err = store.actualStore.Store(ctx, flushed.key, flushed.alg)
+		if err != nil {
+			err = fmt.Errorf("can't store key %s: %w", flushed.key, err)
+		} else {
+			updated = map[string]T{flushed.key: alg}
+		}
 	}
 
-	for key, alg := range store.cache {
-		if store.cache[minKey].lastUsedAt.After(alg.lastUsedAt) {
-			minKey = key
+	for _, flushed := range batch {
+		alg, ok := updated[flushed.key]
+		if !ok {
+			//each key gets its own error, when the batch storer tells them apart
+			keyErr := err
+			failure, found := keyFailure(err, flushed.key)
+			if found {
+				keyErr = failure
+			}
+
+			store.flushFailed(ctx, flushed.key, keyErr, func(shard *cacheShard[T]) {
+				//a dirty cached item is newer and is written by the next flush anyway
+				item, cached := shard.items[flushed.key]
+				_, pending := shard.pending[flushed.key]
+				if !pending && (!cached || !item.dirty()) {
+					shard.pending[flushed.key] = flushed.alg
+				}
+			})
+			continue
 		}
+
+		store.refreshItem(flushed, alg)
 	}
 
-	delete(store.cache, minKey)
+	return err
 }
 
-func (store *CachedStore[T]) Store(ctx context.Context, key string, alg T) (T, error) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// refreshClean reads again the clean items, that other replicas can have
+// changed, with LoadMany when the actual store is a BatchStorer
+func (store *CachedStore[T]) refreshClean(ctx context.Context, items []flushedItem[T]) []error {
+	batchStorer, ok := store.actualStore.(BatchStorer[T])
 
-	_, ok := store.cache[key]
+	batchSize := 1
 	if ok {
-		store.cache[key] = newCachedItem(alg, store.nowProvider())
-		return alg, nil
+		batchSize = flushBatchSize
 	}
 
-	//free some space in the cache
-	if len(store.cache) >= store.cacheSize {
-		removed := store.removeExpiredFromCache()
-		if !removed {
-			store.removeLastCached()
+	return store.runBatches(items, batchSize, func(batch []flushedItem[T]) error {
+		loaded := make(map[string]T, len(batch))
+
+		if batchStorer != nil {
+			keys := make([]string, len(batch))
+			for i, flushed := range batch {
+				keys[i] = flushed.key
+			}
+
+			var err error
+			loaded, err = batchStorer.LoadMany(ctx, keys)
+			if err != nil {
+				return err
+			}
+		} else {
+			alg, err := store.actualStore.Load(ctx, batch[0].key)
+			if err != nil {
+				return fmt.Errorf("can't load key %s: %w", batch[0].key, err)
+			}
+
+			if alg != nil {
+				loaded[batch[0].key] = *alg
+			}
 		}
+
+		for _, flushed := range batch {
+			alg, ok := loaded[flushed.key]
+			if ok {
+				store.refreshItem(flushed, alg)
+			}
+		}
+
+		return nil
+	})
+}
+
+// refreshItem caches the value the actual store has for a flushed item, unless
+// the item changed during the flush
+func (store *CachedStore[T]) refreshItem(flushed flushedItem[T], alg T) {
+	flushed.shard.lock.Lock()
+	defer flushed.shard.lock.Unlock()
+
+	item, ok := flushed.shard.items[flushed.key]
+	if ok && item.version == flushed.version {
+		item.alg = alg
+		item.sort = alg.SortValue()
+		item.persisted = item.version
+	}
+}
+
+// runParallel calls fun with the indexes from 0 to n-1, up to parallelism
+// calls at a time
+func runParallel(n int, parallelism int, fun func(i int)) {
+	semaphore := make(chan struct{}, max(parallelism, 1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			fun(i)
+		}(i)
 	}
 
+	wg.Wait()
+}
+
+func (store *CachedStore[T]) Store(ctx context.Context, key string, alg T) (T, error) {
+	shard := store.shard(key)
+
+	shard.lock.Lock()
+	_, ok := shard.items[key]
+	if ok {
+		shard.set(key, alg, store.nowProvider(), true, store.isExpiredFromCache)
+		shard.lock.Unlock()
+		return alg, nil
+	}
+	shard.lock.Unlock()
+
 	//refresh from inner store
 	alg, err := store.actualStore.Store(ctx, key, alg)
 	if err != nil {
 		return alg, fmt.Errorf("can't store alg: %w", err)
 	}
 
-	if store.cacheSize > 0 {
-		store.cache[key] = newCachedItem(alg, store.nowProvider())
-	}
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
 
-	return alg, nil
+	return shard.set(key, alg, store.nowProvider(), false, store.isExpiredFromCache), nil
 }
 
 func (store *CachedStore[T]) Load(ctx context.Context, key string) (*T, error) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	val, ok := store.cache[key]
-	if ok && !store.isExpiredFromCache(val) {
-		return &val.alg, nil
+	shard := store.shard(key)
+
+	shard.lock.RLock()
+	item, ok := shard.items[key]
+	if ok && !store.isExpiredFromCache(item) {
+		//the callers change the algorithm before storing it
+		alg := cloneAlgorithm(item.alg)
+		shard.lock.RUnlock()
+		return &alg, nil
 	}
+	shard.lock.RUnlock()
 
 	alg, err := store.actualStore.Load(ctx, key)
 	if err != nil {
 		return alg, fmt.Errorf("can't load alg: %w", err)
 	}
 
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
 	if alg == nil {
-		return nil, nil
+		//the key can still wait for the flush
+		pendingAlg, ok := shard.pending[key]
+		if !ok {
+			return nil, nil
+		}
+
+		pendingAlg = cloneAlgorithm(pendingAlg)
+		return &pendingAlg, nil
+	}
+
+	cached := cloneAlgorithm(shard.set(key, *alg, store.nowProvider(), false, store.isExpiredFromCache))
+	return &cached, nil
+}
+
+// Reserve spends the tokens leased from the actual store, so that the replicas
+// sharing it can't spend more than its limit. The leased tokens count as spent
+// in the cached algorithm, that Load returns, until they are given back by the
+// flush. When the actual store isn't an AtomicStorer, or T can't be refunded
+// or configured, the tokens are reserved on the cached algorithm instead.
+// Only Reserve goes through the leases, the other calls of the rate limiter
+// load and store the cached algorithm, and it fails with ErrStoreClosed after
+// Close.
+func (store *CachedStore[T]) Reserve(ctx context.Context, key string, alg T, tokens float64) error {
+	store.closeLock.RLock()
+	defer store.closeLock.RUnlock()
+
+	if store.closed {
+		return fmt.Errorf("can't reserve tokens: %w", ErrStoreClosed)
+	}
+
+	atomicStorer, ok := asAtomicStorer(store.actualStore)
+	_, refunder := any(alg).(Refunder)
+	configurable, isConfigurable := any(alg).(Configurable)
+	if !ok || !refunder || !isConfigurable {
+		return store.reserveOnCache(ctx, key, alg, tokens)
+	}
+
+	//a lease can't be larger than the burst
+	burst := configurable.Config().Burst
+	if tokens > burst {
+		return fmt.Errorf("can't reserve more than %f tokens:%w", burst, ErrOutOfBoundsRequest)
+	}
+
+	shard := store.shard(key)
+
+	shard.lock.Lock()
+	leased := shard.leases[key]
+	if leased >= tokens {
+		shard.leases[key] = leased - tokens
+		shard.lock.Unlock()
+		return nil
 	}
 
-	if store.cacheSize > 0 {
-		store.cache[key] = newCachedItem(*alg, store.nowProvider())
+	//the tokens left are taken now, the lease is requested without the lock
+	delete(shard.leases, key)
+	shard.lock.Unlock()
+
+	needed := tokens - leased
+	leaseSize := math.Min(math.Max(needed, burst*store.leaseFraction), burst)
+
+	stored, err := store.lease(ctx, atomicStorer, key, alg, leaseSize)
+	if errors.As(err, &ErrTooManyRequests{}) && leaseSize > needed {
+		//not enough tokens for a whole lease, but maybe for this request
+		leaseSize = needed
+		stored, err = store.lease(ctx, atomicStorer, key, alg, leaseSize)
+	}
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if err != nil {
+		shard.leases[key] += leased
+		return err
+	}
+
+	shard.leases[key] += leaseSize - needed
+	//the actual store has the lease already, the cached value isn't dirty
+	shard.set(key, cloneAlgorithm(stored), store.nowProvider(), false, store.isExpiredFromCache)
+
+	return nil
+}
+
+func (store *CachedStore[T]) lease(
+	ctx context.Context,
+	atomicStorer AtomicStorer[T],
+	key string,
+	alg T,
+	tokens float64,
+) (T, error) {
+	var reserveErr error
+
+	stored, err := atomicStorer.Update(ctx, key, func(current *T) (T, error) {
+		var algorithm T
+		algorithm, reserveErr = reserveOnCurrent(current, alg, tokens)
+		return algorithm, reserveErr
+	})
+
+	if reserveErr != nil {
+		return stored, reserveErr
+	}
+
+	if err != nil {
+		return stored, fmt.Errorf("can't lease tokens: %w", err)
+	}
+
+	return stored, nil
+}
+
+// returnLeases gives back to the actual store the tokens leased and not spent
+func (store *CachedStore[T]) returnLeases(ctx context.Context, leases map[string]float64) error {
+	var keys []string
+	for key, leased := range leases {
+		if leased > 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	var lock sync.Mutex
+	var errs []error
+
+	runParallel(len(keys), store.flushParallelism, func(i int) {
+		key := keys[i]
+		leased := leases[key]
+
+		atomicStorer := store.actualStore.(AtomicStorer[T])
+		_, err := atomicStorer.Update(ctx, key, func(current *T) (T, error) {
+			if current == nil {
+				//the key expired, it is already full
+				var zero T
+				return zero, errNothingToRefund
+			}
+
+			err := any(*current).(Refunder).Refund(leased)
+			return *current, err
+		})
+		if err != nil && !errors.Is(err, errNothingToRefund) {
+			err = fmt.Errorf("can't return leased tokens of key %s: %w", key, err)
+
+			//the tokens are still leased, they can be spent until the next flush
+			store.flushFailed(ctx, key, err, func(shard *cacheShard[T]) {
+				shard.leases[key] += leased
+			})
+
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+func (store *CachedStore[T]) reserveOnCache(ctx context.Context, key string, alg T, tokens float64) error {
+	loaded, err := store.Load(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	algorithm, err := reserveOnCurrent(loaded, alg, tokens)
+	if err != nil {
+		return err
 	}
 
-	return alg, nil
+	_, err = store.Store(ctx, key, algorithm)
+	return err
 }
@@ -2,9 +2,12 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -54,15 +57,21 @@ func (store *benchStorer[T]) Load(ctx context.Context, key string) (*T, error) {
 	return &alg, nil
 }
 
-func getKeys[T any](m map[string]T) []string {
+func cachedKeys[T Algorithm](store *CachedStore[T]) []string {
 	var keys []string
-	for k := range m {
-		keys = append(keys, k)
+	for _, shard := range store.shards {
+		for k := range shard.items {
+			keys = append(keys, k)
+		}
 	}
 
 	return keys
 }
 
+func leasedTokens[T Algorithm](store *CachedStore[T], key string) float64 {
+	return store.shard(key).leases[key]
+}
+
 func TestCachedStore_Store_StoreIfNotCached(t *testing.T) {
 	ctx := context.Background()
 	logger := testutils.NewNoOpLogger()
@@ -127,21 +136,21 @@ func TestCachedStore_Store_FreeSpaceInTheCacheIfFull_RemoveLast(t *testing.T) {
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, storedItem(newTimeAt(1)), alg) //stored version
 	testutils.RequireEqual(t, 1, internalStore.storeCount)
-	testutils.RequireElementsMatch(t, getKeys(store.cache), []string{"key1"})
+	testutils.RequireElementsMatch(t, cachedKeys(store), []string{"key1"})
 
 	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 1, time.UTC))
 	alg, err = store.Store(ctx, "key2", storedItem(newTimeAt(0)))
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, storedItem(newTimeAt(2)), alg) //stored version
 	testutils.RequireEqual(t, 2, internalStore.storeCount)
-	testutils.RequireElementsMatch(t, getKeys(store.cache), []string{"key1", "key2"})
+	testutils.RequireElementsMatch(t, cachedKeys(store), []string{"key1", "key2"})
 
 	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 2, time.UTC))
 	alg, err = store.Store(ctx, "key3", storedItem(newTimeAt(0)))
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, storedItem(newTimeAt(3)), alg) //stored version
 	testutils.RequireEqual(t, 3, internalStore.storeCount)
-	testutils.RequireElementsMatch(t, getKeys(store.cache), []string{"key2", "key3"})
+	testutils.RequireElementsMatch(t, cachedKeys(store), []string{"key2", "key3"})
 }
 
 func TestCachedStore_Store_FreeSpaceInTheCacheIfFull_RemoveExpired(t *testing.T) {
@@ -164,20 +173,20 @@ func TestCachedStore_Store_FreeSpaceInTheCacheIfFull_RemoveExpired(t *testing.T)
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, storedItem(newTimeAt(1)), alg) //stored version
 	testutils.RequireEqual(t, 1, internalStore.storeCount)
-	testutils.RequireElementsMatch(t, getKeys(store.cache), []string{"key1"})
+	testutils.RequireElementsMatch(t, cachedKeys(store), []string{"key1"})
 
 	alg, err = store.Store(ctx, "key2", storedItem(newTimeAt(0)))
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, storedItem(newTimeAt(2)), alg) //stored version
 	testutils.RequireEqual(t, 2, internalStore.storeCount)
-	testutils.RequireElementsMatch(t, getKeys(store.cache), []string{"key1", "key2"})
+	testutils.RequireElementsMatch(t, cachedKeys(store), []string{"key1", "key2"})
 
 	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 1, 0, 0, 1, time.UTC))
 	alg, err = store.Store(ctx, "key3", storedItem(newTimeAt(0)))
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, storedItem(newTimeAt(3)), alg) //stored version
 	testutils.RequireEqual(t, 3, internalStore.storeCount)
-	testutils.RequireElementsMatch(t, getKeys(store.cache), []string{"key3"})
+	testutils.RequireElementsMatch(t, cachedKeys(store), []string{"key3"})
 }
 
 func TestCachedStore_Load_NotLoadIfCachedAndNotExpired(t *testing.T) {
@@ -287,3 +296,674 @@ func TestCachedStore_Load_LoadIfNotCached(t *testing.T) {
 	testutils.RequireEqual(t, storedItem(newTimeAt(1)), *alg2) //store version
 	testutils.RequireEqual(t, 1, internalStore.loadCount)
 }
+
+func newLeasingStore(actualStore *InMmemoryStore[*TokenBucket]) *CachedStore[*TokenBucket] {
+	return NewCachedStore[*TokenBucket](
+		context.Background(), testutils.NewNoOpLogger(), actualStore,
+		10, 1*time.Hour,
+	).WithLeaseFraction(0.5)
+}
+
+func TestCachedStore_Load_ReturnACopy(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	store := NewCachedStore[*SlidingWindowLog](
+		ctx, testutils.NewNoOpLogger(), NewInMemoryStore[*SlidingWindowLog](10),
+		10, time.Hour,
+	)
+	store.nowProvider = clock.Now
+
+	_, err := store.Store(ctx, "key1", NewSlidingWindowLog(10, time.Minute).WitNowProvider(clock.Now))
+	testutils.RequireNoError(t, err)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireNoError(t, (*loaded).Reserve(1))
+
+	//the change is cached only once it is stored
+	cached, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 0, len((*cached).Entries))
+}
+
+func TestCachedStore_Flush_SnapshotACopy(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	store := NewCachedStore[*SlidingWindowLog](
+		ctx, testutils.NewNoOpLogger(), NewInMemoryStore[*SlidingWindowLog](10),
+		10, time.Hour,
+	)
+	store.nowProvider = clock.Now
+
+	_, err := store.Store(ctx, "key1", NewSlidingWindowLog(10, time.Minute).WitNowProvider(clock.Now))
+	testutils.RequireNoError(t, err)
+
+	shard := store.shard("key1")
+	_, clean := store.snapshotShard(shard, make(map[string]float64))
+	testutils.RequireEqual(t, 1, len(clean))
+
+	//the flush runs without the lock while the cached value changes
+	testutils.RequireNoError(t, shard.items["key1"].alg.Reserve(1))
+	testutils.RequireEqual(t, 0, len(clean[0].alg.Entries))
+}
+
+func TestCloneAlgorithm_NotShareLimits(t *testing.T) {
+	clock := testutils.NewClock(newTimeAt(1))
+	multiLimit := NewMultiLimit(
+		Limit[*TokenBucket]{Name: "second", Algorithm: NewTokenBucket(10, 1).WitNowProvider(clock.Now)},
+	)
+
+	clone := cloneAlgorithm(multiLimit)
+	testutils.RequireNoError(t, clone.Reserve(5))
+
+	testutils.RequireEqual(t, 10.0, multiLimit.Limits[0].Algorithm.Tokens)
+	testutils.RequireEqual(t, 5.0, clone.Limits[0].Algorithm.Tokens)
+}
+
+func TestCachedStore_Reserve_LeaseTokensFromActualStore(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	actualStore := NewInMemoryStore[*TokenBucket](10)
+	replica1 := newLeasingStore(actualStore)
+	replica2 := newLeasingStore(actualStore)
+	newTokenBucket := func() *TokenBucket {
+		return NewTokenBucket(10, 0.001).WitNowProvider(clock.Now)
+	}
+
+	//each replica leases half of the tokens
+	testutils.RequireNoError(t, replica1.Reserve(ctx, "key1", newTokenBucket(), 1))
+	testutils.RequireNoError(t, replica2.Reserve(ctx, "key1", newTokenBucket(), 1))
+	testutils.RequireEqual(t, 4.0, leasedTokens(replica1, "key1"))
+	testutils.RequireEqual(t, 4.0, leasedTokens(replica2, "key1"))
+
+	for i := 0; i < 4; i++ {
+		testutils.RequireNoError(t, replica1.Reserve(ctx, "key1", newTokenBucket(), 1))
+	}
+
+	//the replicas can't spend more than the limit together
+	err := replica1.Reserve(ctx, "key1", newTokenBucket(), 1)
+	if !errors.As(err, &ErrTooManyRequests{}) {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+
+	//the unused tokens go back to the actual store on flush
+	testutils.RequireNoError(t, replica2.flush(ctx))
+	testutils.RequireEqual(t, 0.0, leasedTokens(replica2, "key1"))
+	testutils.RequireNoError(t, replica1.Reserve(ctx, "key1", newTokenBucket(), 4))
+	testutils.RequireEqual(t, 0.0, leasedTokens(replica1, "key1"))
+}
+
+func TestCachedStore_Reserve_RejectAfterClose(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	actualStore := NewInMemoryStore[*TokenBucket](10)
+	store := newLeasingStore(actualStore)
+	store.nowProvider = clock.Now
+
+	testutils.RequireNoError(t, store.Reserve(ctx, "key1", NewTokenBucket(10, 0.001).WitNowProvider(clock.Now), 1))
+	testutils.RequireNoError(t, store.Close(ctx))
+
+	//the lease went back on close, a new one would never be returned
+	err := store.Reserve(ctx, "key1", NewTokenBucket(10, 0.001).WitNowProvider(clock.Now), 1)
+	if !errors.Is(err, ErrStoreClosed) {
+		t.Fatalf("expected ErrStoreClosed, got %v", err)
+	}
+
+	stored, err := actualStore.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 9.0, (*stored).Tokens)
+}
+
+func TestCachedStore_Reserve_LeaseOnlyTheMissingTokens(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	actualStore := NewInMemoryStore[*TokenBucket](10)
+	store := newLeasingStore(actualStore)
+	newTokenBucket := func() *TokenBucket {
+		return NewTokenBucket(10, 0.001).WitNowProvider(clock.Now)
+	}
+
+	testutils.RequireNoError(t, store.Reserve(ctx, "key1", newTokenBucket(), 8))
+	testutils.RequireEqual(t, 0.0, leasedTokens(store, "key1"))
+
+	//a whole lease is not available anymore
+	testutils.RequireNoError(t, store.Reserve(ctx, "key1", newTokenBucket(), 2))
+	testutils.RequireEqual(t, 0.0, leasedTokens(store, "key1"))
+}
+
+func TestCachedStore_Reserve_RejectMoreThanBurst(t *testing.T) {
+	ctx := context.Background()
+	actualStore := NewInMemoryStore[*TokenBucket](10)
+	store := newLeasingStore(actualStore)
+
+	err := store.Reserve(ctx, "key1", NewTokenBucket(10, 0.001), 11)
+	if !errors.Is(err, ErrOutOfBoundsRequest) {
+		t.Fatalf("expected ErrOutOfBoundsRequest, got %v", err)
+	}
+
+	//no lease was taken
+	testutils.RequireEqual(t, 0.0, leasedTokens(store, "key1"))
+	stored, err := actualStore.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	if stored != nil {
+		t.Fatalf("expected no stored key, got %v", *stored)
+	}
+}
+
+func TestCachedStore_Reserve_LoadCountsLeasedTokensAsSpent(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	store := newLeasingStore(NewInMemoryStore[*TokenBucket](10))
+	store.nowProvider = clock.Now
+
+	newTokenBucket := func() *TokenBucket {
+		return NewTokenBucket(10, 0.001).WitNowProvider(clock.Now)
+	}
+
+	testutils.RequireNoError(t, store.Reserve(ctx, "key1", newTokenBucket(), 1))
+	testutils.RequireEqual(t, 4.0, leasedTokens(store, "key1"))
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 5.0, (*loaded).Tokens)
+
+	//the second lease reaches the cached value too
+	testutils.RequireNoError(t, store.Reserve(ctx, "key1", newTokenBucket(), 5))
+	testutils.RequireEqual(t, 4.0, leasedTokens(store, "key1"))
+
+	loaded, err = store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 0.0, (*loaded).Tokens)
+}
+
+func TestCachedStore_Store_ShardedCacheKeepsItsSize(t *testing.T) {
+	ctx := context.Background()
+	internalStore := newBenchStorer()
+	store := NewCachedStore(
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		1000, 1*time.Hour,
+	)
+	testutils.RequireEqual(t, 15, len(store.shards))
+
+	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC))
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		internalStore.alg[key] = storedItem(newTimeAt(1))
+
+		_, err := store.Store(ctx, key, storedItem(newTimeAt(0)))
+		testutils.RequireNoError(t, err)
+	}
+
+	testutils.RequireEqual(t, 1000, len(cachedKeys(store)))
+}
+
+type hookStorer struct {
+	*benchStorer[storedItem]
+	onStore func()
+}
+
+func (store *hookStorer) Store(ctx context.Context, key string, alg storedItem) (storedItem, error) {
+	if store.onStore != nil {
+		onStore := store.onStore
+		store.onStore = nil
+		onStore()
+	}
+
+	return store.benchStorer.Store(ctx, key, alg)
+}
+
+func TestCachedStore_Flush_KeepLocalChangesMadeDuringFlush(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &hookStorer{benchStorer: newBenchStorer()}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		10, 1*time.Hour,
+	)
+
+	internalStore.alg = map[string]storedItem{"key1": storedItem(newTimeAt(1))}
+	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC))
+	for i := 0; i < 2; i++ {
+		_, err := store.Store(ctx, "key1", storedItem(newTimeAt(1)))
+		testutils.RequireNoError(t, err)
+	}
+
+	//a request changes the key while it is being flushed, without waiting for the flush
+	internalStore.onStore = func() {
+		_, err := store.Store(ctx, "key1", storedItem(newTimeAt(2)))
+		testutils.RequireNoError(t, err)
+	}
+
+	testutils.RequireNoError(t, store.flush(ctx))
+	alg, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, storedItem(newTimeAt(2)), *alg)
+}
+
+type batchStorer struct {
+	lock           sync.Mutex
+	alg            map[string]storedItem
+	storeManyCalls int
+	inFlight       int
+	maxInFlight    int
+	err            error            //returned by the store calls when set
+	keyErrs        map[string]error //returned by StoreMany for each of the keys
+	hang           bool             //the store calls wait for the context when set
+}
+
+func (store *batchStorer) fail(ctx context.Context) error {
+	store.lock.Lock()
+	err, hang := store.err, store.hang
+	store.lock.Unlock()
+
+	if hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (store *batchStorer) enter() {
+	store.lock.Lock()
+	store.inFlight++
+	store.maxInFlight = max(store.maxInFlight, store.inFlight)
+	store.lock.Unlock()
+
+	//give the other calls the time to start
+	time.Sleep(10 * time.Millisecond)
+}
+
+func (store *batchStorer) exit() {
+	store.lock.Lock()
+	store.inFlight--
+	store.lock.Unlock()
+}
+
+func (store *batchStorer) Store(ctx context.Context, key string, alg storedItem) (storedItem, error) {
+	store.enter()
+	defer store.exit()
+
+	err := store.fail(ctx)
+	if err != nil {
+		return alg, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.alg[key] = alg
+	return alg, nil
+}
+
+func (store *batchStorer) Load(ctx context.Context, key string) (*storedItem, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	alg, ok := store.alg[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &alg, nil
+}
+
+func (store *batchStorer) StoreMany(ctx context.Context, algs map[string]storedItem) (map[string]storedItem, error) {
+	store.enter()
+	defer store.exit()
+
+	err := store.fail(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.storeManyCalls++
+
+	stored := make(map[string]storedItem, len(algs))
+	var errs []error
+	for key, alg := range algs {
+		keyErr, failed := store.keyErrs[key]
+		if failed {
+			errs = append(errs, ErrKeyFailed{Key: key, Err: keyErr})
+			continue
+		}
+
+		store.alg[key] = alg
+		stored[key] = alg
+	}
+
+	return stored, errors.Join(errs...)
+}
+
+func (store *batchStorer) LoadMany(ctx context.Context, keys []string) (map[string]storedItem, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	algs := make(map[string]storedItem)
+	for _, key := range keys {
+		alg, ok := store.alg[key]
+		if ok {
+			algs[key] = alg
+		}
+	}
+
+	return algs, nil
+}
+
+// onlyStorer hides the BatchStorer methods of the wrapped store
+type onlyStorer struct {
+	AlgorithmStorer[storedItem]
+}
+
+// storeKeys caches count keys with local changes to flush
+func storeKeys(t *testing.T, store *CachedStore[storedItem], count int) {
+	t.Helper()
+
+	ctx := context.Background()
+	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC))
+	for i := 0; i < count; i++ {
+		//the first store writes through, the second one is local
+		for j := 0; j < 2; j++ {
+			_, err := store.Store(ctx, fmt.Sprintf("key%d", i), storedItem(newTimeAt(1)))
+			testutils.RequireNoError(t, err)
+		}
+	}
+}
+
+func TestCachedStore_Flush_StoreInBatches(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		1000, 1*time.Hour,
+	)
+
+	storeKeys(t, store, 250)
+	testutils.RequireNoError(t, store.flush(ctx))
+
+	//each shard keeps less than a batch, the items are batched across shards
+	testutils.RequireEqual(t, 3, internalStore.storeManyCalls)
+	testutils.RequireEqual(t, 250, len(internalStore.alg))
+}
+
+func TestCachedStore_Flush_BoundedParallelism(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), onlyStorer{internalStore},
+		100, 1*time.Hour,
+	).WithFlushParallelism(4)
+
+	storeKeys(t, store, 20)
+	internalStore.maxInFlight = 0
+	testutils.RequireNoError(t, store.flush(ctx))
+
+	testutils.RequireEqual(t, 0, internalStore.storeManyCalls)
+	testutils.RequireEqual(t, 4, internalStore.maxInFlight)
+}
+
+func TestCachedStore_Close_FlushOnce(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		10, 1*time.Hour,
+	)
+
+	storeKeys(t, store, 1)
+	_, err := store.Store(ctx, "key0", storedItem(newTimeAt(2)))
+	testutils.RequireNoError(t, err)
+
+	testutils.RequireNoError(t, store.Close(ctx))
+	testutils.RequireEqual(t, storedItem(newTimeAt(2)), internalStore.alg["key0"])
+	testutils.RequireEqual(t, 1, internalStore.storeManyCalls)
+
+	testutils.RequireNoError(t, store.Close(ctx))
+	store.Stop()
+	testutils.RequireEqual(t, 1, internalStore.storeManyCalls)
+
+	var nilStore *CachedStore[storedItem]
+	nilStore.Stop()
+}
+
+func TestCachedStore_Close_FlushWithinTheContextDeadline(t *testing.T) {
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		context.Background(), testutils.NewNoOpLogger(), internalStore,
+		10, 1*time.Hour,
+	)
+
+	storeKeys(t, store, 1)
+	internalStore.hang = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := store.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	testutils.RequireEqual(t, err, store.Close(context.Background()))
+}
+
+func TestRunPeriodically_StopWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls atomic.Int32
+	exited := runPeriodically(ctx, time.Millisecond, func() {
+		calls.Add(1)
+	})
+
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("expected the periodic function to stop")
+	}
+}
+
+func TestCachedStore_Flush_RetryFailedKeys(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+
+	var failedKeys []string
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), onlyStorer{internalStore},
+		10, 1*time.Hour,
+	).WithFlushErrorHandler(func(key string, err error) {
+		if !errors.Is(err, errStoreDown) {
+			t.Errorf("expected errStoreDown, got %v", err)
+		}
+
+		failedKeys = append(failedKeys, key)
+	})
+
+	storeKeys(t, store, 1)
+	_, err := store.Store(ctx, "key0", storedItem(newTimeAt(2)))
+	testutils.RequireNoError(t, err)
+
+	internalStore.err = errStoreDown
+	err = store.flush(ctx)
+	if !errors.Is(err, errStoreDown) {
+		t.Fatalf("expected errStoreDown, got %v", err)
+	}
+	testutils.RequireElementsMatch(t, []string{"key0"}, failedKeys)
+
+	//the failed key is retried even if it left the cache
+	shard := store.shard("key0")
+	shard.delete(shard.items["key0"])
+
+	internalStore.err = nil
+	testutils.RequireNoError(t, store.flush(ctx))
+	testutils.RequireEqual(t, storedItem(newTimeAt(2)), internalStore.alg["key0"])
+}
+
+func TestCachedStore_Flush_ReportEachKeyItsOwnError(t *testing.T) {
+	ctx := context.Background()
+	errKey0 := errors.New("key0 too large")
+	errKey1 := errors.New("key1 throttled")
+	internalStore := &batchStorer{
+		alg:     make(map[string]storedItem),
+		keyErrs: map[string]error{"key0": errKey0, "key1": errKey1},
+	}
+
+	failures := make(map[string]error)
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		10, 1*time.Hour,
+	).WithFlushErrorHandler(func(key string, err error) {
+		failures[key] = err
+	})
+
+	storeKeys(t, store, 3)
+
+	err := store.flush(ctx)
+	if !errors.Is(err, errKey0) || !errors.Is(err, errKey1) {
+		t.Fatalf("expected the errors of both keys, got %v", err)
+	}
+
+	testutils.RequireEqual(t, 2, len(failures))
+	if !errors.Is(failures["key0"], errKey0) || errors.Is(failures["key0"], errKey1) {
+		t.Fatalf("expected only the error of key0, got %v", failures["key0"])
+	}
+	if !errors.Is(failures["key1"], errKey1) || errors.Is(failures["key1"], errKey0) {
+		t.Fatalf("expected only the error of key1, got %v", failures["key1"])
+	}
+	testutils.RequireEqual(t, storedItem(newTimeAt(1)), internalStore.alg["key2"])
+}
+
+func TestCachedStore_Flush_WriteOnlyDirtyItems(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		10, 1*time.Hour,
+	)
+
+	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, err := store.Store(ctx, "key1", storedItem(newTimeAt(1)))
+	testutils.RequireNoError(t, err)
+
+	testutils.RequireNoError(t, store.flush(ctx))
+	testutils.RequireEqual(t, 0, internalStore.storeManyCalls)
+
+	_, err = store.Store(ctx, "key1", storedItem(newTimeAt(2)))
+	testutils.RequireNoError(t, err)
+
+	testutils.RequireNoError(t, store.flush(ctx))
+	testutils.RequireNoError(t, store.flush(ctx))
+	testutils.RequireEqual(t, 1, internalStore.storeManyCalls)
+	testutils.RequireEqual(t, storedItem(newTimeAt(2)), internalStore.alg["key1"])
+}
+
+func TestCachedStore_Flush_RefreshCleanItems(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		10, 1*time.Hour,
+	)
+
+	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, err := store.Store(ctx, "key1", storedItem(newTimeAt(1)))
+	testutils.RequireNoError(t, err)
+
+	//another replica changes the key
+	internalStore.alg["key1"] = storedItem(newTimeAt(3))
+
+	testutils.RequireNoError(t, store.flush(ctx))
+	alg, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, storedItem(newTimeAt(3)), *alg)
+	testutils.RequireEqual(t, 0, internalStore.storeManyCalls)
+}
+
+func TestCachedStore_Flush_WriteBackEvictedDirtyItems(t *testing.T) {
+	ctx := context.Background()
+	internalStore := &batchStorer{alg: make(map[string]storedItem)}
+	store := NewCachedStore[storedItem](
+		ctx, testutils.NewNoOpLogger(), internalStore,
+		1, 1*time.Hour,
+	)
+
+	store.nowProvider = testutils.NowProvider(time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, err := store.Store(ctx, "key1", storedItem(newTimeAt(1)))
+	testutils.RequireNoError(t, err)
+	_, err = store.Store(ctx, "key1", storedItem(newTimeAt(2)))
+	testutils.RequireNoError(t, err)
+
+	//key1 leaves the cache before it is flushed
+	_, err = store.Store(ctx, "key2", storedItem(newTimeAt(1)))
+	testutils.RequireNoError(t, err)
+	testutils.RequireElementsMatch(t, []string{"key2"}, cachedKeys(store))
+
+	alg, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, storedItem(newTimeAt(2)), *alg)
+
+	testutils.RequireNoError(t, store.flush(ctx))
+	testutils.RequireEqual(t, storedItem(newTimeAt(2)), internalStore.alg["key1"])
+}
+
+// fun gets a bucket of its own for each key, not the one stored
+func benchmarkCachedStore(b *testing.B, fun func(store *CachedStore[*TokenBucket], key string, alg *TokenBucket)) {
+	ctx := context.Background()
+	keys := make([]string, 1000)
+	algs := make([]*TokenBucket, len(keys))
+	store := NewCachedStore[*TokenBucket](
+		ctx, testutils.NewNoOpLogger(), NewInMemoryStore[*TokenBucket](len(keys)),
+		2*len(keys), 1*time.Hour,
+	)
+	defer store.Stop()
+
+	newAlg := func() *TokenBucket {
+		//a bucket that isn't full doesn't expire from the cache
+		alg := NewTokenBucket(10, 0.001)
+		alg.Tokens = 0
+		return alg
+	}
+
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		algs[i] = newAlg()
+
+		_, err := store.Store(ctx, keys[i], newAlg())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var next atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1))
+		for pb.Next() {
+			fun(store, keys[i%len(keys)], algs[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// run with -cpu=1,2,4,8 to see how the cache scales with GOMAXPROCS
+func BenchmarkCachedStore_Load_Parallel(b *testing.B) {
+	ctx := context.Background()
+	benchmarkCachedStore(b, func(store *CachedStore[*TokenBucket], key string, _ *TokenBucket) {
+		_, _ = store.Load(ctx, key)
+	})
+}
+
+func BenchmarkCachedStore_Store_Parallel(b *testing.B) {
+	ctx := context.Background()
+	benchmarkCachedStore(b, func(store *CachedStore[*TokenBucket], key string, alg *TokenBucket) {
+		_, _ = store.Store(ctx, key, alg)
+	})
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+type Release func(ctx context.Context) error
+
+type ConcurrencyLimiter struct {
+	rateLimiter RateLimiter[*Semaphore]
+}
+
+func NewConcurrencyLimiter(
+	new func() *Semaphore,
+	algStorer AlgorithmStorer[*Semaphore],
+) ConcurrencyLimiter {
+	return ConcurrencyLimiter{
+		rateLimiter: NewRateLimiter(new, algStorer),
+	}
+}
+
+func newLeaseID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (c ConcurrencyLimiter) atomicStorer() (AtomicStorer[*Semaphore], error) {
+	//with Load and Store two callers can take the last slot from the same
+	//version of the semaphore, and the store keeps only one of the leases
+	atomicStorer, ok := asAtomicStorer(c.rateLimiter.algStorer)
+	if !ok {
+		return nil, fmt.Errorf("can't limit concurrency without an atomic storer: %w", ErrNotSupported)
+	}
+
+	return atomicStorer, nil
+}
+
+func (c ConcurrencyLimiter) Acquire(ctx context.Context, key string) (Release, error) {
+	atomicStorer, err := c.atomicStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	id := newLeaseID()
+
+	_, err = c.rateLimiter.atomicUpdate(ctx, atomicStorer, key, func(semaphore *Semaphore) error {
+		err := semaphore.Acquire(id, 1)
+		if err != nil {
+			return fmt.Errorf("can't acquire a slot: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		return c.release(ctx, atomicStorer, key, id)
+	}, nil
+}
+
+func (c ConcurrencyLimiter) release(ctx context.Context, atomicStorer AtomicStorer[*Semaphore], key string, id string) error {
+	_, err := c.rateLimiter.atomicUpdate(ctx, atomicStorer, key, func(semaphore *Semaphore) error {
+		semaphore.Release(id)
+		return nil
+	})
+
+	return err
+}
@@ -0,0 +1,133 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestConcurrencyLimiter_Acquire_RejectWhenAllSlotsAreUsed(t *testing.T) {
+	ctx := context.Background()
+	limiter := core.NewConcurrencyLimiter(
+		func() *core.Semaphore {
+			return core.NewSemaphore(2, time.Minute)
+		},
+		core.NewInMemoryStore[*core.Semaphore](10),
+	)
+
+	_, err := limiter.Acquire(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	_, err = limiter.Acquire(ctx, "key1")
+	testutils.RequireNoError(t, err)
+
+	_, err = limiter.Acquire(ctx, "key1")
+	tooManyReqErr := requireTooManyRequests(t, err)
+	if tooManyReqErr.RetryAfter <= 0 || tooManyReqErr.RetryAfter > time.Minute {
+		t.Errorf("unexpected retry after %s", tooManyReqErr.RetryAfter)
+	}
+
+	//other keys have their own slots
+	_, err = limiter.Acquire(ctx, "key2")
+	testutils.RequireNoError(t, err)
+}
+
+func TestConcurrencyLimiter_Acquire_ReleaseFreeTheSlot(t *testing.T) {
+	ctx := context.Background()
+	limiter := core.NewConcurrencyLimiter(
+		func() *core.Semaphore {
+			return core.NewSemaphore(1, time.Minute)
+		},
+		core.NewInMemoryStore[*core.Semaphore](10),
+	)
+
+	release, err := limiter.Acquire(ctx, "key1")
+	testutils.RequireNoError(t, err)
+
+	_, err = limiter.Acquire(ctx, "key1")
+	requireTooManyRequests(t, err)
+
+	testutils.RequireNoError(t, release(ctx))
+
+	_, err = limiter.Acquire(ctx, "key1")
+	testutils.RequireNoError(t, err)
+}
+
+func TestConcurrencyLimiter_Acquire_NoLostLeases(t *testing.T) {
+	ctx := context.Background()
+	limiter := core.NewConcurrencyLimiter(
+		func() *core.Semaphore {
+			return core.NewSemaphore(10, time.Minute)
+		},
+		core.NewInMemoryStore[*core.Semaphore](10),
+	)
+
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := limiter.Acquire(ctx, "key1")
+			if err == nil {
+				acquired.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	testutils.RequireEqual(t, int32(10), acquired.Load())
+}
+
+type loadStoreOnly struct {
+	core.AlgorithmStorer[*core.Semaphore]
+}
+
+func TestConcurrencyLimiter_Acquire_NotSupportedWithoutAtomicStorer(t *testing.T) {
+	limiter := core.NewConcurrencyLimiter(
+		func() *core.Semaphore {
+			return core.NewSemaphore(10, time.Minute)
+		},
+		loadStoreOnly{core.NewInMemoryStore[*core.Semaphore](10)},
+	)
+
+	_, err := limiter.Acquire(context.Background(), "key1")
+	if !errors.Is(err, core.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestSemaphore_Acquire_ExpiredLeasesAreReleased(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	semaphore := core.NewSemaphore(1, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, semaphore.Acquire("lease1", 1))
+	testutils.RequireEqual(t, testutils.NewTimeAt(1).Add(time.Minute), semaphore.ExpireAt())
+
+	tooManyReqErr := requireTooManyRequests(t, semaphore.Acquire("lease2", 1))
+	testutils.RequireEqual(t, time.Minute, tooManyReqErr.RetryAfter)
+
+	//the holder of lease1 never released it
+	clock.Advance(time.Minute)
+	testutils.RequireNoError(t, semaphore.Acquire("lease2", 1))
+	testutils.RequireEqual(t, false, semaphore.HasLease("lease1"))
+}
+
+func TestSemaphore_SortValue_IncreaseAfterAcquireAndRelease(t *testing.T) {
+	semaphore := core.NewSemaphore(1, time.Minute)
+
+	sortValue1 := semaphore.SortValue()
+	testutils.RequireNoError(t, semaphore.Acquire("lease1", 1))
+	sortValue2 := semaphore.SortValue()
+	semaphore.Release("lease1")
+	sortValue3 := semaphore.SortValue()
+
+	if sortValue1 >= sortValue2 || sortValue2 >= sortValue3 {
+		t.Errorf("Semaphore.SortValue() not increasing: %v, %v, %v", sortValue1, sortValue2, sortValue3)
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// locations caches the locations loaded by name, the windows read from the
+// stores only have the name and must not change on reads
+var locations sync.Map
+
+type FixedWindow struct {
+	Limit       float64
+	Window      time.Duration
+	Location    string
+	WindowStart time.Time
+	Tokens      float64
+	location    *time.Location
+	nowProvider func() time.Time //for test
+}
+
+var _ CheckedAlgorithm = &FixedWindow{}
+var _ Configurable = &FixedWindow{}
+var _ Cloner[*FixedWindow] = &FixedWindow{}
+
+func NewFixedWindow(limit float64, window time.Duration) *FixedWindow {
+	fw := &FixedWindow{
+		Limit:    limit,
+		Window:   window,
+		Location: time.UTC.String(),
+		location: time.UTC,
+	}
+
+	fw.WindowStart = fw.windowStartAt(time.Now(), time.UTC)
+	return fw
+}
+
+func (fw *FixedWindow) WithLocation(location *time.Location) *FixedWindow {
+	fw.Location = location.String()
+	fw.location = location
+	fw.WindowStart = fw.windowStartAt(fw.now(), location)
+	return fw
+}
+
+func (fw *FixedWindow) WitNowProvider(fun func() time.Time) *FixedWindow {
+	fw.nowProvider = fun
+	fw.WindowStart = fw.windowStartAt(fun(), fw.location)
+	return fw
+}
+
+func (fw *FixedWindow) now() time.Time {
+	if fw.nowProvider != nil {
+		return fw.nowProvider()
+	} else {
+		return time.Now()
+	}
+}
+
+func (fw *FixedWindow) loadLocation() (*time.Location, error) {
+	if fw.location != nil {
+		return fw.location, nil
+	}
+
+	cached, ok := locations.Load(fw.Location)
+	if ok {
+		return cached.(*time.Location), nil
+	}
+
+	location, err := time.LoadLocation(fw.Location)
+	if err != nil {
+		return nil, fmt.Errorf("can't load location %s: %w", fw.Location, err)
+	}
+
+	locations.Store(fw.Location, location)
+	return location, nil
+}
+
+// windowDays is the length of the window in days, when it is made of whole days
+func (fw *FixedWindow) windowDays() (int, bool) {
+	const day = 24 * time.Hour
+	if fw.Window < day || fw.Window%day != 0 {
+		return 0, false
+	}
+
+	return int(fw.Window / day), true
+}
+
+func (fw *FixedWindow) windowStartAt(now time.Time, location *time.Location) time.Time {
+	days, ok := fw.windowDays()
+	if ok {
+		//the days start at midnight even when they last 23 or 25 hours
+		year, month, day := now.In(location).Date()
+		epochDays := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / int64(24*time.Hour/time.Second)
+		return time.Date(year, month, day-int(epochDays%int64(days)), 0, 0, 0, 0, location)
+	}
+
+	//align the window to the wall clock of the location
+	_, offset := now.In(location).Zone()
+	shift := time.Duration(offset) * time.Second
+	return now.Add(shift).Truncate(fw.Window).Add(-shift).In(location)
+}
+
+func (fw *FixedWindow) Reserve(tokens float64) error {
+	if tokens > fw.Limit {
+		return fmt.Errorf("can't reserve more than %f tokens:%w", fw.Limit, ErrOutOfBoundsRequest)
+	}
+
+	location, err := fw.loadLocation()
+	if err != nil {
+		return err
+	}
+
+	now := fw.now()
+	windowStart := fw.windowStartAt(now, location)
+	if windowStart.After(fw.WindowStart) {
+		fw.WindowStart = windowStart
+		fw.Tokens = 0
+	}
+
+	if fw.Tokens+tokens > fw.Limit {
+		return ErrTooManyRequests{RetryAfter: fw.ExpireAt().Sub(now)}
+	}
+
+	fw.Tokens += tokens
+
+	return nil
+}
+
+func (fw *FixedWindow) Check(tokens float64) error {
+	//reserve on a copy to leave the state untouched
+	clone := *fw
+	return clone.Reserve(tokens)
+}
+
+func (fw *FixedWindow) Config() AlgorithmConfig {
+	return AlgorithmConfig{Burst: fw.Limit, Window: fw.Window}
+}
+
+func (fw *FixedWindow) Configure(config AlgorithmConfig) error {
+	if config.Burst > 0 {
+		fw.Limit = config.Burst
+	}
+
+	if config.Window > 0 && config.Window != fw.Window {
+		location, err := fw.loadLocation()
+		if err != nil {
+			return err
+		}
+
+		//the tokens spent in the current window count in the first window of the new size
+		now := fw.now()
+		if !now.Before(fw.ExpireAt()) {
+			fw.Tokens = 0
+		}
+
+		fw.Window = config.Window
+		fw.WindowStart = fw.windowStartAt(now, location)
+	}
+
+	return nil
+}
+
+func (fw *FixedWindow) Clone() *FixedWindow {
+	clone := *fw
+	return &clone
+}
+
+func (fw *FixedWindow) SortValue() string {
+	return sortValue(fw.WindowStart, fw.Tokens)
+}
+
+func (fw *FixedWindow) ExpireAt() time.Time {
+	days, ok := fw.windowDays()
+	if !ok {
+		return fw.WindowStart.Add(fw.Window)
+	}
+
+	location, err := fw.loadLocation()
+	if err != nil {
+		//Reserve fails with the same error
+		return fw.WindowStart.Add(fw.Window)
+	}
+
+	return fw.WindowStart.In(location).AddDate(0, 0, days)
+}
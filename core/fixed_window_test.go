@@ -0,0 +1,127 @@
+package core_test
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestFixedWindow_Reserve_RejectUntilEndOfWindow(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1).Add(20 * time.Second))
+	fw := core.NewFixedWindow(2, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, fw.Reserve(1))
+	testutils.RequireNoError(t, fw.Reserve(1))
+
+	err := requireTooManyRequests(t, fw.Reserve(1))
+	testutils.RequireEqual(t, 40*time.Second, err.RetryAfter)
+	testutils.RequireEqual(t, testutils.NewTimeAt(1).Add(time.Minute), fw.ExpireAt())
+
+	clock.Advance(err.RetryAfter)
+	testutils.RequireNoError(t, fw.Reserve(2))
+	testutils.RequireEqual(t, testutils.NewTimeAt(1).Add(2*time.Minute), fw.ExpireAt())
+}
+
+func TestFixedWindow_Reserve_AlignToLocationDay(t *testing.T) {
+	location, err := time.LoadLocation("Asia/Tokyo")
+	testutils.RequireNoError(t, err)
+
+	//10:00 in Tokyo
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	fw := core.NewFixedWindow(1, 24*time.Hour).WitNowProvider(clock.Now).WithLocation(location)
+
+	testutils.RequireNoError(t, fw.Reserve(1))
+
+	tooManyReqErr := requireTooManyRequests(t, fw.Reserve(1))
+	testutils.RequireEqual(t, 14*time.Hour, tooManyReqErr.RetryAfter)
+	testutils.RequireEqual(t, time.Date(2000, 1, 2, 0, 0, 0, 0, location), fw.ExpireAt())
+}
+
+func TestFixedWindow_Reserve_AlignToLocationDayAcrossDSTChanges(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	testutils.RequireNoError(t, err)
+
+	for _, day := range []struct {
+		date   time.Time
+		length time.Duration
+	}{
+		{date: time.Date(2024, 3, 10, 0, 0, 0, 0, location), length: 23 * time.Hour},
+		{date: time.Date(2024, 11, 3, 0, 0, 0, 0, location), length: 25 * time.Hour},
+	} {
+		clock := testutils.NewClock(day.date.Add(12 * time.Hour))
+		fw := core.NewFixedWindow(1, 24*time.Hour).WitNowProvider(clock.Now).WithLocation(location)
+
+		testutils.RequireNoError(t, fw.Reserve(1))
+		testutils.RequireEqual(t, day.date, fw.WindowStart)
+		testutils.RequireEqual(t, day.date.AddDate(0, 0, 1), fw.ExpireAt())
+		testutils.RequireEqual(t, day.length, fw.ExpireAt().Sub(fw.WindowStart))
+
+		tooManyReqErr := requireTooManyRequests(t, fw.Reserve(1))
+		testutils.RequireEqual(t, day.length-12*time.Hour, tooManyReqErr.RetryAfter)
+
+		clock.Advance(tooManyReqErr.RetryAfter)
+		testutils.RequireNoError(t, fw.Reserve(1))
+	}
+}
+
+func TestFixedWindow_Reserve_AlignWeekToLocationMidnight(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	testutils.RequireNoError(t, err)
+
+	clock := testutils.NewClock(time.Date(2024, 3, 12, 15, 0, 0, 0, location))
+	fw := core.NewFixedWindow(1, 7*24*time.Hour).WitNowProvider(clock.Now).WithLocation(location)
+
+	testutils.RequireNoError(t, fw.Reserve(1))
+	//the weeks start on thursday, as the epoch
+	testutils.RequireEqual(t, time.Date(2024, 3, 7, 0, 0, 0, 0, location), fw.WindowStart)
+	testutils.RequireEqual(t, time.Date(2024, 3, 14, 0, 0, 0, 0, location), fw.ExpireAt())
+}
+
+func TestFixedWindow_ExpireAt_ConcurrentlyAfterUnmarshal(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	testutils.RequireNoError(t, err)
+
+	clock := testutils.NewClock(time.Date(2024, 3, 12, 15, 0, 0, 0, location))
+	fw := core.NewFixedWindow(1, 24*time.Hour).WitNowProvider(clock.Now).WithLocation(location)
+	testutils.RequireNoError(t, fw.Reserve(1))
+
+	data, err := json.Marshal(fw)
+	testutils.RequireNoError(t, err)
+
+	//the stores read the windows under a read lock, ExpireAt must not write
+	unmarshalled := &core.FixedWindow{}
+	testutils.RequireNoError(t, json.Unmarshal(data, unmarshalled))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testutils.RequireEqual(t, time.Date(2024, 3, 13, 0, 0, 0, 0, location), unmarshalled.ExpireAt().In(location))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFixedWindow_SortValue_IncreaseAfterReserve(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	fw := core.NewFixedWindow(10, time.Minute).WitNowProvider(clock.Now)
+
+	sortValues := []string{fw.SortValue()}
+	for _, advance := range []time.Duration{0, time.Second, time.Minute} {
+		clock.Advance(advance)
+		testutils.RequireNoError(t, fw.Reserve(1))
+		sortValues = append(sortValues, fw.SortValue())
+	}
+
+	for i := 1; i < len(sortValues); i++ {
+		if sortValues[i-1] >= sortValues[i] {
+			t.Errorf("FixedWindow.SortValue() = %v is not less than %v", sortValues[i-1], sortValues[i])
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type GCRA struct {
+	Burst       float64
+	Rate        float64
+	TAT         time.Time        //theoretical arrival time
+	nowProvider func() time.Time //for test
+}
+
+var _ CheckedAlgorithm = &GCRA{}
+var _ Configurable = &GCRA{}
+var _ Drainer = &GCRA{}
+var _ Cloner[*GCRA] = &GCRA{}
+
+func NewGCRA(burst, rate float64) *GCRA {
+	return &GCRA{
+		Burst: burst,
+		Rate:  rate,
+		TAT:   time.Now(),
+	}
+}
+
+func (g *GCRA) WitNowProvider(fun func() time.Time) *GCRA {
+	g.TAT = fun()
+	g.nowProvider = fun
+	return g
+}
+
+func (g *GCRA) now() time.Time {
+	if g.nowProvider != nil {
+		return g.nowProvider()
+	} else {
+		return time.Now()
+	}
+}
+
+func (g *GCRA) emissionInterval(tokens float64) time.Duration {
+	return time.Duration(tokens / g.Rate * float64(time.Second))
+}
+
+func (g *GCRA) Reserve(tokens float64) error {
+	if tokens > g.Burst {
+		return fmt.Errorf("can't reserve more than %f tokens:%w", g.Burst, ErrOutOfBoundsRequest)
+	}
+
+	now := g.now()
+	tat := g.TAT
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(g.emissionInterval(tokens))
+	//the burst is the tolerance allowed before the theoretical arrival time
+	allowAt := newTat.Add(-g.emissionInterval(g.Burst))
+	if allowAt.After(now) {
+		return ErrTooManyRequests{RetryAfter: allowAt.Sub(now)}
+	}
+
+	g.TAT = newTat
+
+	return nil
+}
+
+func (g *GCRA) Drain(retryAfter time.Duration) error {
+	if retryAfter <= 0 {
+		return fmt.Errorf("can't drain for %s:%w", retryAfter, ErrOutOfBoundsRequest)
+	}
+
+	//no token is allowed before retryAfter, as with an empty bucket then
+	drained := g.now().Add(retryAfter).Add(g.emissionInterval(g.Burst))
+	if drained.After(g.TAT) {
+		g.TAT = drained
+	}
+
+	return nil
+}
+
+func (g *GCRA) Check(tokens float64) error {
+	//reserve on a copy to leave the state untouched
+	clone := *g
+	return clone.Reserve(tokens)
+}
+
+func (g *GCRA) Config() AlgorithmConfig {
+	return AlgorithmConfig{Burst: g.Burst, Rate: g.Rate}
+}
+
+func (g *GCRA) Configure(config AlgorithmConfig) error {
+	if config.Burst > 0 {
+		g.Burst = config.Burst
+	}
+
+	if config.Rate > 0 && config.Rate != g.Rate {
+		//the tokens spent are the ones still to emit before now
+		now := g.now()
+		spent := math.Min(math.Max(g.TAT.Sub(now).Seconds()*g.Rate, 0), g.Burst)
+		g.Rate = config.Rate
+		g.TAT = now.Add(g.emissionInterval(spent))
+	}
+
+	return nil
+}
+
+func (g *GCRA) Clone() *GCRA {
+	clone := *g
+	return &clone
+}
+
+func (g *GCRA) SortValue() string {
+	return sortValue(g.TAT, 0)
+}
+
+func (g *GCRA) ExpireAt() time.Time {
+	now := g.now()
+	if g.TAT.Before(now) {
+		return now
+	}
+
+	return g.TAT
+}
@@ -0,0 +1,94 @@
+package core_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestGCRA_Reserve_SameAsTokenBucket(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	random := rand.New(rand.NewSource(1))
+
+	for _, config := range []struct{ burst, rate float64 }{{10, 1}, {1, 10}, {100, 0.5}, {5, 5}} {
+		gcra := core.NewGCRA(config.burst, config.rate).WitNowProvider(clock.Now)
+		tokenBucket := core.NewTokenBucket(config.burst, config.rate).WitNowProvider(clock.Now)
+
+		maxAdvance := int64(2 / config.rate * float64(time.Second))
+
+		for i := 0; i < 1000; i++ {
+			clock.Advance(time.Duration(random.Int63n(maxAdvance)))
+			tokens := float64(random.Intn(int(config.burst)) + 1)
+
+			gcraErr := gcra.Reserve(tokens)
+			tokenBucketErr := tokenBucket.Reserve(tokens)
+
+			var gcraTooMany, tokenBucketTooMany core.ErrTooManyRequests
+			gcraRejected := errors.As(gcraErr, &gcraTooMany)
+			tokenBucketRejected := errors.As(tokenBucketErr, &tokenBucketTooMany)
+
+			retryAfterDiff := gcraTooMany.RetryAfter - tokenBucketTooMany.RetryAfter
+			if retryAfterDiff.Abs() > time.Microsecond {
+				t.Fatalf("%v: different retry after at %d: gcra=%v token bucket=%v", config, i, gcraTooMany.RetryAfter, tokenBucketTooMany.RetryAfter)
+			}
+
+			//a decision can differ only when it is within rounding
+			if gcraRejected != tokenBucketRejected &&
+				gcraTooMany.RetryAfter+tokenBucketTooMany.RetryAfter > time.Microsecond {
+				t.Fatalf("%v: different decision at %d: gcra=%v token bucket=%v", config, i, gcraErr, tokenBucketErr)
+			}
+		}
+	}
+}
+
+func TestGCRA_Reserve_AllowBurstThenRate(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	gcra := core.NewGCRA(5, 1).WitNowProvider(clock.Now)
+
+	for i := 0; i < 5; i++ {
+		testutils.RequireNoError(t, gcra.Reserve(1))
+	}
+
+	tooManyReqErr := requireTooManyRequests(t, gcra.Reserve(1))
+	testutils.RequireEqual(t, time.Second, tooManyReqErr.RetryAfter)
+
+	clock.Advance(tooManyReqErr.RetryAfter)
+	testutils.RequireNoError(t, gcra.Reserve(1))
+	testutils.RequireEqual(t, testutils.NewTimeAt(1).Add(6*time.Second), gcra.ExpireAt())
+}
+
+func TestGCRA_SortValue_IncreaseAfterReserve(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	gcra := core.NewGCRA(10, 1).WitNowProvider(clock.Now)
+
+	sortValue1 := gcra.SortValue()
+	testutils.RequireNoError(t, gcra.Reserve(1))
+	sortValue2 := gcra.SortValue()
+
+	if sortValue1 >= sortValue2 {
+		t.Errorf("GCRA.SortValue() = %v is not less than %v", sortValue1, sortValue2)
+	}
+}
+
+func TestGCRA_Drain_SameAsTokenBucket(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	gcra := core.NewGCRA(10, 1).WitNowProvider(clock.Now)
+	tokenBucket := core.NewTokenBucket(10, 1).WitNowProvider(clock.Now)
+
+	for _, alg := range []core.Drainer{gcra, tokenBucket} {
+		testutils.RequireNoError(t, alg.Reserve(3))
+		testutils.RequireNoError(t, alg.Drain(5*time.Second))
+
+		//no token until the drain is over, then one per second
+		err := requireTooManyRequests(t, alg.Reserve(1))
+		testutils.RequireEqual(t, 6*time.Second, err.RetryAfter)
+	}
+
+	clock.Advance(6 * time.Second)
+	testutils.RequireNoError(t, gcra.Reserve(1))
+	testutils.RequireNoError(t, tokenBucket.Reserve(1))
+}
@@ -15,6 +15,7 @@ type InMmemoryStore[T Algorithm] struct {
 }
 
 var _ AlgorithmStorer[*TokenBucket] = &InMmemoryStore[*TokenBucket]{}
+var _ AtomicStorer[*TokenBucket] = &InMmemoryStore[*TokenBucket]{}
 
 var ErrMaxSizeReached = errors.New("in memory max size reached")
 
@@ -35,7 +36,9 @@ func (m *InMmemoryStore[T]) Load(_ context.Context, key string) (*T, error) {
 		return nil, nil
 	}
 
-	return &alg, nil
+	//the callers change what they load, keep the stored state to the writers
+	clone := cloneAlgorithm(alg)
+	return &clone, nil
 }
 
 func (m *InMmemoryStore[T]) Store(_ context.Context, key string, alg T) (T, error) {
@@ -53,7 +56,32 @@ func (m *InMmemoryStore[T]) Store(_ context.Context, key string, alg T) (T, erro
 		return alg, nil
 	}
 
-	m.data[key] = alg
+	m.data[key] = cloneAlgorithm(alg)
+
+	return alg, nil
+}
+
+func (m *InMmemoryStore[T]) Update(_ context.Context, key string, fun func(alg *T) (T, error)) (T, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var current *T
+
+	cached, ok := m.data[key]
+	if ok {
+		//fun may change the algorithm before failing, give it a copy
+		clone := cloneAlgorithm(cached)
+		current = &clone
+	} else if len(m.data) >= m.maxSize {
+		return cached, ErrMaxSizeReached
+	}
+
+	alg, err := fun(current)
+	if err != nil {
+		return alg, err
+	}
+
+	m.data[key] = cloneAlgorithm(alg)
 
 	return alg, nil
 }
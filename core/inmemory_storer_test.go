@@ -0,0 +1,47 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestInMemoryStore_Update_KeepStateOnError(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	store := core.NewInMemoryStore[*core.TokenBucket](10)
+
+	_, err := store.Store(ctx, "key1", core.NewTokenBucket(10, 1).WitNowProvider(clock.Now))
+	testutils.RequireNoError(t, err)
+
+	errUpdate := errors.New("update failed")
+	_, err = store.Update(ctx, "key1", func(alg **core.TokenBucket) (*core.TokenBucket, error) {
+		testutils.RequireNoError(t, (*alg).Reserve(5))
+		return *alg, errUpdate
+	})
+	testutils.RequireEqual(t, errUpdate, err)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 10.0, (*loaded).Tokens)
+}
+
+func TestInMemoryStore_Load_ReturnCopy(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	store := core.NewInMemoryStore[*core.TokenBucket](10)
+
+	_, err := store.Store(ctx, "key1", core.NewTokenBucket(10, 1).WitNowProvider(clock.Now))
+	testutils.RequireNoError(t, err)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireNoError(t, (*loaded).Reserve(5))
+
+	loaded, err = store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 10.0, (*loaded).Tokens)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type LeakyBucket struct {
+	Capacity    float64
+	LeakRate    float64
+	NextSlot    time.Time
+	nowProvider func() time.Time //for test
+}
+
+var _ DelayedAlgorithm = &LeakyBucket{}
+var _ CheckedAlgorithm = &LeakyBucket{}
+var _ Configurable = &LeakyBucket{}
+var _ Refunder = &LeakyBucket{}
+var _ Cloner[*LeakyBucket] = &LeakyBucket{}
+
+func NewLeakyBucket(capacity, leakRate float64) *LeakyBucket {
+	return &LeakyBucket{
+		Capacity: capacity,
+		LeakRate: leakRate,
+		NextSlot: time.Now(),
+	}
+}
+
+func (lb *LeakyBucket) WitNowProvider(fun func() time.Time) *LeakyBucket {
+	lb.NextSlot = fun()
+	lb.nowProvider = fun
+	return lb
+}
+
+func (lb *LeakyBucket) now() time.Time {
+	if lb.nowProvider != nil {
+		return lb.nowProvider()
+	} else {
+		return time.Now()
+	}
+}
+
+func (lb *LeakyBucket) leakDuration(tokens float64) time.Duration {
+	return time.Duration(tokens / lb.LeakRate * float64(time.Second))
+}
+
+func (lb *LeakyBucket) ReserveWithDelay(tokens float64, maxDelay time.Duration) (time.Duration, error) {
+	if tokens > lb.Capacity {
+		return 0, fmt.Errorf("can't reserve more than %f tokens:%w", lb.Capacity, ErrOutOfBoundsRequest)
+	}
+
+	now := lb.now()
+	slot := lb.NextSlot
+	if slot.Before(now) {
+		slot = now
+	}
+
+	delay := slot.Sub(now)
+	//tokens still in the queue waiting to leak
+	queued := delay.Seconds() * lb.LeakRate
+	if queued+tokens > lb.Capacity {
+		return 0, ErrTooManyRequests{RetryAfter: lb.leakDuration(queued + tokens - lb.Capacity)}
+	}
+
+	if delay > maxDelay {
+		return 0, ErrTooManyRequests{RetryAfter: delay - maxDelay}
+	}
+
+	lb.NextSlot = slot.Add(lb.leakDuration(tokens))
+
+	return delay, nil
+}
+
+func (lb *LeakyBucket) Reserve(tokens float64) error {
+	_, err := lb.ReserveWithDelay(tokens, 0)
+	return err
+}
+
+func (lb *LeakyBucket) Refund(tokens float64) error {
+	if tokens <= 0 {
+		return fmt.Errorf("can't refund %f tokens:%w", tokens, ErrOutOfBoundsRequest)
+	}
+
+	//give back the last slots of the queue
+	now := lb.now()
+	if lb.NextSlot.After(now) {
+		lb.NextSlot = lb.NextSlot.Add(-lb.leakDuration(tokens))
+		if lb.NextSlot.Before(now) {
+			lb.NextSlot = now
+		}
+	}
+
+	return nil
+}
+
+func (lb *LeakyBucket) Check(tokens float64) error {
+	//reserve on a copy to leave the state untouched
+	clone := *lb
+	return clone.Reserve(tokens)
+}
+
+func (lb *LeakyBucket) Config() AlgorithmConfig {
+	return AlgorithmConfig{Burst: lb.Capacity, Rate: lb.LeakRate}
+}
+
+func (lb *LeakyBucket) Configure(config AlgorithmConfig) error {
+	if config.Burst > 0 {
+		lb.Capacity = config.Burst
+	}
+
+	if config.Rate > 0 && config.Rate != lb.LeakRate {
+		//the tokens still in the queue leak at the new rate
+		now := lb.now()
+		queued := math.Min(math.Max(lb.NextSlot.Sub(now).Seconds()*lb.LeakRate, 0), lb.Capacity)
+		lb.LeakRate = config.Rate
+		lb.NextSlot = now.Add(lb.leakDuration(queued))
+	}
+
+	return nil
+}
+
+func (lb *LeakyBucket) Clone() *LeakyBucket {
+	clone := *lb
+	return &clone
+}
+
+func (lb *LeakyBucket) SortValue() string {
+	return sortValue(lb.NextSlot, 0)
+}
+
+func (lb *LeakyBucket) ExpireAt() time.Time {
+	now := lb.now()
+	if lb.NextSlot.Before(now) {
+		return now
+	}
+
+	return lb.NextSlot
+}
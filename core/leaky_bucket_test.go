@@ -0,0 +1,68 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestLeakyBucket_ReserveWithDelay_QueueRequests(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	lb := core.NewLeakyBucket(5, 2).WitNowProvider(clock.Now)
+
+	for i := 0; i < 5; i++ {
+		delay, err := lb.ReserveWithDelay(1, time.Hour)
+		testutils.RequireNoError(t, err)
+		testutils.RequireEqual(t, time.Duration(i)*500*time.Millisecond, delay)
+	}
+
+	//the queue is full
+	_, err := lb.ReserveWithDelay(1, time.Hour)
+	tooManyReqErr := requireTooManyRequests(t, err)
+	testutils.RequireEqual(t, 500*time.Millisecond, tooManyReqErr.RetryAfter)
+}
+
+func TestLeakyBucket_ReserveWithDelay_RejectIfDelayIsTooLong(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	lb := core.NewLeakyBucket(5, 1).WitNowProvider(clock.Now)
+
+	_, err := lb.ReserveWithDelay(2, time.Second)
+	testutils.RequireNoError(t, err)
+
+	_, err = lb.ReserveWithDelay(1, time.Second)
+	tooManyReqErr := requireTooManyRequests(t, err)
+	testutils.RequireEqual(t, time.Second, tooManyReqErr.RetryAfter)
+
+	clock.Advance(tooManyReqErr.RetryAfter)
+	delay, err := lb.ReserveWithDelay(1, time.Second)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, time.Second, delay)
+}
+
+func TestLeakyBucket_Reserve_AcceptOnlyWithoutQueue(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	lb := core.NewLeakyBucket(5, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, lb.Reserve(1))
+	requireTooManyRequests(t, lb.Reserve(1))
+
+	clock.Advance(time.Second)
+	testutils.RequireNoError(t, lb.Reserve(1))
+}
+
+func TestLeakyBucket_Refund_GiveBackLastSlots(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	lb := core.NewLeakyBucket(10, 2).WitNowProvider(clock.Now)
+
+	_, err := lb.ReserveWithDelay(4, time.Minute)
+	testutils.RequireNoError(t, err)
+
+	testutils.RequireNoError(t, lb.Refund(1))
+	testutils.RequireEqual(t, clock.Now().Add(1500*time.Millisecond), lb.NextSlot)
+
+	//the slots that already leaked can't be given back
+	testutils.RequireNoError(t, lb.Refund(10))
+	testutils.RequireEqual(t, clock.Now(), lb.NextSlot)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Limit[T CheckedAlgorithm] struct {
+	Name      string
+	Algorithm T
+}
+
+// MultiLimit enforces several limits of the same algorithm type T on a key,
+// such as a FixedWindow per second and per hour. T must be a concrete type:
+// the stores can't unmarshal the limits back into an interface
+type MultiLimit[T CheckedAlgorithm] struct {
+	Limits []Limit[T]
+}
+
+var _ CheckedAlgorithm = &MultiLimit[*TokenBucket]{}
+var _ Cloner[*MultiLimit[*TokenBucket]] = &MultiLimit[*TokenBucket]{}
+
+func NewMultiLimit[T CheckedAlgorithm](limits ...Limit[T]) *MultiLimit[T] {
+	return &MultiLimit[T]{
+		Limits: limits,
+	}
+}
+
+func (m *MultiLimit[T]) Check(tokens float64) error {
+	var tripped *ErrTooManyRequests
+
+	for _, limit := range m.Limits {
+		err := limit.Algorithm.Check(tokens)
+
+		var tooManyReqErr ErrTooManyRequests
+		switch {
+		case errors.As(err, &tooManyReqErr):
+			if tripped == nil || tooManyReqErr.RetryAfter > tripped.RetryAfter {
+				tooManyReqErr.Limit = limit.Name
+				tripped = &tooManyReqErr
+			}
+		case err != nil:
+			return fmt.Errorf("can't check limit %s: %w", limit.Name, err)
+		}
+	}
+
+	if tripped != nil {
+		return *tripped
+	}
+
+	return nil
+}
+
+func (m *MultiLimit[T]) Reserve(tokens float64) error {
+	//nothing is reserved unless every limit accepts
+	err := m.Check(tokens)
+	if err != nil {
+		return err
+	}
+
+	for _, limit := range m.Limits {
+		err := limit.Algorithm.Reserve(tokens)
+		if err != nil {
+			return fmt.Errorf("can't reserve limit %s: %w", limit.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *MultiLimit[T]) Clone() *MultiLimit[T] {
+	limits := make([]Limit[T], len(m.Limits))
+	for i, limit := range m.Limits {
+		limits[i] = Limit[T]{Name: limit.Name, Algorithm: cloneAlgorithm(limit.Algorithm)}
+	}
+
+	clone := *m
+	clone.Limits = limits
+	return &clone
+}
+
+func (m *MultiLimit[T]) SortValue() string {
+	sortValues := make([]string, 0, len(m.Limits))
+	for _, limit := range m.Limits {
+		sortValues = append(sortValues, limit.Algorithm.SortValue())
+	}
+
+	return strings.Join(sortValues, "|")
+}
+
+func (m *MultiLimit[T]) ExpireAt() time.Time {
+	var expireAt time.Time
+	for _, limit := range m.Limits {
+		if limit.Algorithm.ExpireAt().After(expireAt) {
+			expireAt = limit.Algorithm.ExpireAt()
+		}
+	}
+
+	return expireAt
+}
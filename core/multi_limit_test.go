@@ -0,0 +1,99 @@
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func newMultiLimit(clock *testutils.Clock) *core.MultiLimit[*core.FixedWindow] {
+	return core.NewMultiLimit(
+		core.Limit[*core.FixedWindow]{
+			Name:      "minute",
+			Algorithm: core.NewFixedWindow(2, time.Minute).WitNowProvider(clock.Now),
+		},
+		core.Limit[*core.FixedWindow]{
+			Name:      "hour",
+			Algorithm: core.NewFixedWindow(3, time.Hour).WitNowProvider(clock.Now),
+		},
+	)
+}
+
+func TestMultiLimit_Reserve_RejectIfAnyLimitRejects(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	multiLimit := newMultiLimit(clock)
+
+	testutils.RequireNoError(t, multiLimit.Reserve(1))
+	testutils.RequireNoError(t, multiLimit.Reserve(1))
+
+	tooManyReqErr := requireTooManyRequests(t, multiLimit.Reserve(1))
+	testutils.RequireEqual(t, "minute", tooManyReqErr.Limit)
+	testutils.RequireEqual(t, time.Minute, tooManyReqErr.RetryAfter)
+
+	clock.Advance(time.Minute)
+	testutils.RequireNoError(t, multiLimit.Reserve(1))
+
+	tooManyReqErr = requireTooManyRequests(t, multiLimit.Reserve(1))
+	testutils.RequireEqual(t, "hour", tooManyReqErr.Limit)
+	testutils.RequireEqual(t, 59*time.Minute, tooManyReqErr.RetryAfter)
+}
+
+func TestMultiLimit_Reserve_ReportLongestRetryAfter(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	multiLimit := newMultiLimit(clock)
+
+	testutils.RequireNoError(t, multiLimit.Reserve(2))
+	clock.Advance(time.Minute)
+	testutils.RequireNoError(t, multiLimit.Reserve(1))
+
+	//both limits reject, the hour one is the longest
+	tooManyReqErr := requireTooManyRequests(t, multiLimit.Reserve(2))
+	testutils.RequireEqual(t, "hour", tooManyReqErr.Limit)
+	testutils.RequireEqual(t, 59*time.Minute, tooManyReqErr.RetryAfter)
+}
+
+func TestMultiLimit_Reserve_NotSpendTokensIfRejected(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	multiLimit := newMultiLimit(clock)
+
+	testutils.RequireNoError(t, multiLimit.Reserve(2))
+	clock.Advance(time.Minute)
+
+	//the minute limit accepts 2 tokens but the hour one doesn't
+	requireTooManyRequests(t, multiLimit.Reserve(2))
+
+	testutils.RequireNoError(t, multiLimit.Reserve(1))
+	testutils.RequireEqual(t, 1.0, multiLimit.Limits[0].Algorithm.Tokens)
+	testutils.RequireEqual(t, 3.0, multiLimit.Limits[1].Algorithm.Tokens)
+}
+
+func TestMultiLimit_RateLimiter_InMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.MultiLimit[*core.TokenBucket] {
+			return core.NewMultiLimit(
+				core.Limit[*core.TokenBucket]{Name: "burst", Algorithm: core.NewTokenBucket(2, 1)},
+				core.Limit[*core.TokenBucket]{Name: "hour", Algorithm: core.NewTokenBucket(3, 3.0/3600)},
+			)
+		},
+		core.NewInMemoryStore[*core.MultiLimit[*core.TokenBucket]](10),
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	tooManyReqErr := requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 2))
+	testutils.RequireEqual(t, "hour", tooManyReqErr.Limit)
+}
+
+func TestTokenBucket_Check_NotChangeTheState(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	tokenBucket := core.NewTokenBucket(2, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, tokenBucket.Check(1))
+	testutils.RequireNoError(t, tokenBucket.Check(2))
+	testutils.RequireEqual(t, 2.0, tokenBucket.Tokens)
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNoPolicy = errors.New("no policy for the key")
+
+// the zero fields keep the current value, the algorithms use only the
+// fields they need
+type AlgorithmConfig struct {
+	Burst  float64
+	Rate   float64 //tokens per second
+	Window time.Duration
+}
+
+// PolicyResolver returns ErrNoPolicy for the keys that use the parameters
+// of the algorithms created by the rate limiter
+type PolicyResolver func(ctx context.Context, key string) (AlgorithmConfig, error)
+
+type Configurable interface {
+	Config() AlgorithmConfig
+	//switch to the new parameters, keeping the tokens already spent
+	Configure(config AlgorithmConfig) error
+}
+
+// TieredPolicy resolves the policy of the tier of each key, the keys without
+// a tier or with an unknown one have no policy
+func TieredPolicy(
+	tierOf func(ctx context.Context, key string) (string, error),
+	tiers map[string]AlgorithmConfig,
+) PolicyResolver {
+	return func(ctx context.Context, key string) (AlgorithmConfig, error) {
+		tier, err := tierOf(ctx, key)
+		if err != nil {
+			return AlgorithmConfig{}, err
+		}
+
+		config, ok := tiers[tier]
+		if !ok {
+			return AlgorithmConfig{}, fmt.Errorf("can't find tier %s: %w", tier, ErrNoPolicy)
+		}
+
+		return config, nil
+	}
+}
+
+// OverridePolicy uses the configs set for specific keys and falls back on
+// policy for the other keys, policy can be nil
+func OverridePolicy(overrides map[string]AlgorithmConfig, policy PolicyResolver) PolicyResolver {
+	return func(ctx context.Context, key string) (AlgorithmConfig, error) {
+		config, ok := overrides[key]
+		if ok {
+			return config, nil
+		}
+
+		if policy == nil {
+			return AlgorithmConfig{}, ErrNoPolicy
+		}
+
+		return policy(ctx, key)
+	}
+}
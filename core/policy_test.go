@@ -0,0 +1,160 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestRateLimiter_WithPolicy_TieredLimits(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	tiers := map[string]string{"tenant1": "premium"}
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001).WitNowProvider(clock.Now)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	).WithPolicy(core.TieredPolicy(
+		func(ctx context.Context, key string) (string, error) {
+			return tiers[key], nil
+		},
+		map[string]core.AlgorithmConfig{"premium": {Burst: 20, Rate: 0.01}},
+	))
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "tenant1", 15))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "tenant2", 2))
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "tenant2", 1))
+
+	//the spent tokens are kept when the plan changes
+	tiers["tenant2"] = "premium"
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "tenant2", 18))
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "tenant2", 1))
+
+	delete(tiers, "tenant1")
+	status, err := rateLimiter.Status(ctx, "tenant1", 1)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 2.0, status.Limit)
+	testutils.RequireEqual(t, 0.0, status.Remaining)
+}
+
+func TestRateLimiter_WithPolicy_StatusLeavesStoredStateUnchanged(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	store := core.NewInMemoryStore[*core.TokenBucket](10)
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001).WitNowProvider(clock.Now)
+		},
+		store,
+	).WithPolicy(func(ctx context.Context, key string) (core.AlgorithmConfig, error) {
+		return core.AlgorithmConfig{Burst: 20, Rate: 0.01}, nil
+	})
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 5))
+	stored, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	before := **stored
+
+	clock.Advance(time.Minute)
+	status, err := rateLimiter.Status(ctx, "key1", 1)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 20.0, status.Limit)
+
+	stored, err = store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, before.LastRefillTime, (*stored).LastRefillTime)
+	testutils.RequireEqual(t, before.Tokens, (*stored).Tokens)
+}
+
+func TestRateLimiter_WithPolicy_RejectedReserveLeavesStoredStateUnchanged(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	store := core.NewInMemoryStore[*core.TokenBucket](10)
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001).WitNowProvider(clock.Now)
+		},
+		store,
+	).WithPolicy(func(ctx context.Context, key string) (core.AlgorithmConfig, error) {
+		return core.AlgorithmConfig{Burst: 20, Rate: 0.01}, nil
+	})
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 20))
+	stored, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	before := **stored
+
+	clock.Advance(time.Minute)
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 10))
+
+	stored, err = store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, before.LastRefillTime, (*stored).LastRefillTime)
+	testutils.RequireEqual(t, before.Tokens, (*stored).Tokens)
+}
+
+func TestRateLimiter_WithPolicy_ResolverError(t *testing.T) {
+	ctx := context.Background()
+	resolverErr := errors.New("tenant service unavailable")
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 1)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	).WithPolicy(func(ctx context.Context, key string) (core.AlgorithmConfig, error) {
+		return core.AlgorithmConfig{}, resolverErr
+	})
+
+	err := rateLimiter.Reserve(ctx, "key1", 1)
+	if !errors.Is(err, resolverErr) {
+		t.Errorf("expected the resolver error, got %v", err)
+	}
+}
+
+func TestOverridePolicy(t *testing.T) {
+	ctx := context.Background()
+	policy := core.OverridePolicy(
+		map[string]core.AlgorithmConfig{"key1": {Burst: 10}},
+		core.TieredPolicy(
+			func(ctx context.Context, key string) (string, error) {
+				return "free", nil
+			},
+			map[string]core.AlgorithmConfig{"free": {Burst: 1}},
+		),
+	)
+
+	config, err := policy(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, core.AlgorithmConfig{Burst: 10}, config)
+
+	config, err = policy(ctx, "key2")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, core.AlgorithmConfig{Burst: 1}, config)
+
+	_, err = core.OverridePolicy(nil, nil)(ctx, "key1")
+	if !errors.Is(err, core.ErrNoPolicy) {
+		t.Errorf("expected ErrNoPolicy, got %v", err)
+	}
+}
+
+func TestFixedWindow_Configure_KeepTokensOfCurrentWindow(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	window := core.NewFixedWindow(10, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, window.Reserve(8))
+	clock.Advance(30 * time.Second)
+
+	testutils.RequireNoError(t, window.Configure(core.AlgorithmConfig{Burst: 20, Window: time.Hour}))
+	testutils.RequireEqual(t, clock.Now().Truncate(time.Hour), window.WindowStart)
+	testutils.RequireNoError(t, window.Check(12))
+	requireTooManyRequests(t, window.Check(13))
+}
@@ -2,15 +2,36 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
 	"time"
 )
 
+var ErrNotSupported = errors.New("operation not supported")
+
+// ErrHoldExpired is returned when a hold is committed or cancelled after its
+// timeout, its tokens were already given back
+var ErrHoldExpired = errors.New("hold expired")
+
+// ErrHoldNotFound is returned when the key has no hold with that id, as when
+// the key expired or the expired hold was released by a later request
+var ErrHoldNotFound = errors.New("hold not found")
+
+// ErrTooManyRequests is returned when the tokens can't be reserved now, with
+// how long to wait before retrying
 type ErrTooManyRequests struct {
 	RetryAfter time.Duration
+	Limit      string //name of the limit that rejected the request, if any
 }
 
 func (e ErrTooManyRequests) Error() string {
+	if e.Limit != "" {
+		return fmt.Sprintf("too many requests on limit %s, retry after %s", e.Limit, e.RetryAfter)
+	}
+
 	return fmt.Sprintf("too many requests, retry after %s", e.RetryAfter)
 }
 
@@ -20,14 +41,161 @@ type Algorithm interface {
 	ExpireAt() time.Time
 }
 
+const sortValueTimeLayout = "2006-01-02T15:04:05.000000000"
+
+func sortValue(t time.Time, counter float64) string {
+	//ordered by time first and then by counter, for the algorithms
+	//whose ExpireAt doesn't change on every reservation
+	return fmt.Sprintf("%s|%030.9f", t.UTC().Format(sortValueTimeLayout), counter)
+}
+
+type CheckedAlgorithm interface {
+	Algorithm
+	//return the error Reserve would return, without changing the state
+	Check(tokens float64) error
+}
+
+type Cloner[T Algorithm] interface {
+	//return a copy sharing no state, the stores hand out copies so that the
+	//callers change the stored algorithms only through the stores
+	Clone() T
+}
+
+type Refunder interface {
+	Algorithm
+	//give back tokens that were reserved but not used
+	Refund(tokens float64) error
+}
+
+type Drainer interface {
+	Algorithm
+	//spend every token until retryAfter, as when the upstream rate limits us
+	Drain(retryAfter time.Duration) error
+}
+
+type Holder interface {
+	//reserve the tokens until the hold is committed or cancelled, an expired
+	//hold gives its tokens back
+	Hold(id string, tokens float64, timeout time.Duration) error
+	//return ErrHoldExpired or ErrHoldNotFound when the hold can't be finished
+	Commit(id string) error
+	Cancel(id string) error
+}
+
+type Status struct {
+	Limit      float64
+	Remaining  float64
+	ResetAt    time.Time     //when all the tokens will be available again
+	RetryAfter time.Duration //how long to wait before the cost can be reserved
+}
+
+type Inspector interface {
+	//return the status as of now without changing the state
+	Inspect(tokens float64) Status
+}
+
+type DelayedAlgorithm interface {
+	Algorithm
+	//reserve a slot in the future, up to maxDelay from now, and return how
+	//long the caller has to wait for it
+	ReserveWithDelay(tokens float64, maxDelay time.Duration) (time.Duration, error)
+}
+
+// DryRunFunc receives the rejections a dry run rate limiter lets through
+type DryRunFunc func(ctx context.Context, key string, tokens float64, err ErrTooManyRequests)
+
+func LogDryRun(logger *slog.Logger) DryRunFunc {
+	return func(ctx context.Context, key string, tokens float64, err ErrTooManyRequests) {
+		logger.InfoContext(ctx, "rate limit dry run rejection",
+			"key", key,
+			"tokens", tokens,
+			"retryAfter", err.RetryAfter,
+			"limit", err.Limit,
+		)
+	}
+}
+
 type AlgorithmStorer[T Algorithm] interface {
 	Store(ctx context.Context, key string, alg T) (T, error)
 	Load(ctx context.Context, key string) (*T, error)
 }
 
+type AtomicStorer[T Algorithm] interface {
+	//fun receives nil if the key is missing, the value it returns is stored
+	//only if it doesn't return an error and no one else updated the key
+	Update(ctx context.Context, key string, fun func(alg *T) (T, error)) (T, error)
+}
+
+// atomicWrapper is implemented by the storers, as ResilientStore, that are
+// atomic only if the store they wrap is
+type atomicWrapper interface {
+	atomic() bool
+}
+
+func asAtomicStorer[T Algorithm](storer AlgorithmStorer[T]) (AtomicStorer[T], bool) {
+	wrapper, ok := storer.(atomicWrapper)
+	if ok && !wrapper.atomic() {
+		return nil, false
+	}
+
+	atomicStorer, ok := storer.(AtomicStorer[T])
+	return atomicStorer, ok
+}
+
+type ReserveStorer[T Algorithm] interface {
+	//reserve the tokens on the store side, alg is used when the key is missing
+	Reserve(ctx context.Context, key string, alg T, tokens float64) error
+}
+
+// ErrKeyFailed is the failure of a single key in a batch
+type ErrKeyFailed struct {
+	Key string
+	Err error
+}
+
+func (e ErrKeyFailed) Error() string {
+	return fmt.Sprintf("key %s: %s", e.Key, e.Err)
+}
+
+func (e ErrKeyFailed) Unwrap() error {
+	return e.Err
+}
+
+// keyFailure finds the ErrKeyFailed of key among the errors joined in err
+func keyFailure(err error, key string) (ErrKeyFailed, bool) {
+	switch wrapped := err.(type) {
+	case ErrKeyFailed:
+		return wrapped, wrapped.Key == key
+	case interface{ Unwrap() []error }:
+		for _, child := range wrapped.Unwrap() {
+			keyErr, ok := keyFailure(child, key)
+			if ok {
+				return keyErr, true
+			}
+		}
+	case interface{ Unwrap() error }:
+		return keyFailure(wrapped.Unwrap(), key)
+	}
+
+	return ErrKeyFailed{}, false
+}
+
+type BatchStorer[T Algorithm] interface {
+	//store several keys at once as Store does, the result has the stored
+	//value of the keys that succeeded and the error joins an ErrKeyFailed for
+	//each failure, or is the error of the whole batch
+	StoreMany(ctx context.Context, algs map[string]T) (map[string]T, error)
+	//the missing keys aren't in the result
+	LoadMany(ctx context.Context, keys []string) (map[string]T, error)
+}
+
 type RateLimiter[alg Algorithm] struct {
-	algStorer AlgorithmStorer[alg]
-	new       func() alg
+	algStorer     AlgorithmStorer[alg]
+	new           func() alg
+	maxQueueDelay time.Duration
+	holdTimeout   time.Duration
+	policy        PolicyResolver
+	dryRun        DryRunFunc
 }
 
 func NewRateLimiter[alg Algorithm](
@@ -35,9 +203,101 @@ func NewRateLimiter[alg Algorithm](
 	algStorer AlgorithmStorer[alg],
 ) RateLimiter[alg] {
 	return RateLimiter[alg]{
-		new:       new,
-		algStorer: algStorer,
+		new:           new,
+		algStorer:     algStorer,
+		maxQueueDelay: math.MaxInt64,
+		holdTimeout:   time.Minute,
+	}
+}
+
+func (r RateLimiter[Alg]) WithMaxQueueDelay(maxQueueDelay time.Duration) RateLimiter[Alg] {
+	r.maxQueueDelay = maxQueueDelay
+	return r
+}
+
+func (r RateLimiter[Alg]) WithHoldTimeout(holdTimeout time.Duration) RateLimiter[Alg] {
+	r.holdTimeout = holdTimeout
+	return r
+}
+
+// WithPolicy sets the parameters of each key, the algorithms must implement
+// Configurable
+func (r RateLimiter[Alg]) WithPolicy(policy PolicyResolver) RateLimiter[Alg] {
+	r.policy = policy
+	return r
+}
+
+// WithDryRun updates the state as usual but never rejects the requests of
+// Reserve and Wait, the rejections are reported to fun instead
+func (r RateLimiter[Alg]) WithDryRun(fun DryRunFunc) RateLimiter[Alg] {
+	r.dryRun = fun
+	return r
+}
+
+func (r RateLimiter[Alg]) dryRunError(ctx context.Context, key string, tokens float64, err error) error {
+	var tooManyReqErr ErrTooManyRequests
+	if r.dryRun != nil && errors.As(err, &tooManyReqErr) {
+		r.dryRun(ctx, key, tokens, tooManyReqErr)
+		return nil
+	}
+
+	return err
+}
+
+// configure applies the policy to a copy of algorithm, the stores can hand out
+// a shared pointer that must change only when the result is stored
+func (r RateLimiter[Alg]) configure(ctx context.Context, key string, algorithm Alg) (Alg, error) {
+	if r.policy == nil {
+		return algorithm, nil
+	}
+
+	_, ok := any(algorithm).(Configurable)
+	if !ok {
+		return algorithm, fmt.Errorf("can't apply the policy of key %s: %w", key, ErrNotSupported)
 	}
+
+	config, err := r.policy(ctx, key)
+	if errors.Is(err, ErrNoPolicy) {
+		//back to the default parameters, in case the key had a policy before
+		config = any(r.new()).(Configurable).Config()
+	} else if err != nil {
+		return algorithm, fmt.Errorf("can't resolve the policy of key %s: %w", key, err)
+	}
+
+	configured, err := configuredClone(algorithm, config)
+	if err != nil {
+		return algorithm, fmt.Errorf("can't apply the policy of key %s: %w", key, err)
+	}
+
+	return configured, nil
+}
+
+// configuredClone configures a copy of an algorithm implementing Configurable
+func configuredClone[Alg Algorithm](algorithm Alg, config AlgorithmConfig) (Alg, error) {
+	configured := cloneAlgorithm(algorithm)
+	err := any(configured).(Configurable).Configure(config)
+	return configured, err
+}
+
+// reserveOnCurrent reserves the tokens on a copy of current, with the
+// parameters of alg as for a new algorithm, or on alg when the key is missing
+func reserveOnCurrent[Alg Algorithm](current *Alg, alg Alg, tokens float64) (Alg, error) {
+	if current == nil {
+		return alg, alg.Reserve(tokens)
+	}
+
+	configurable, ok := any(alg).(Configurable)
+	if !ok {
+		algorithm := cloneAlgorithm(*current)
+		return algorithm, algorithm.Reserve(tokens)
+	}
+
+	algorithm, err := configuredClone(*current, configurable.Config())
+	if err != nil {
+		return algorithm, err
+	}
+
+	return algorithm, algorithm.Reserve(tokens)
 }
 
 func (r RateLimiter[Alg]) loadAlgorithm(ctx context.Context, key string) (Alg, error) {
@@ -48,28 +308,279 @@ func (r RateLimiter[Alg]) loadAlgorithm(ctx context.Context, key string) (Alg, e
 		return defaultAlg, fmt.Errorf("can't load data from key: %w", err)
 	}
 
-	if algorithm == nil {
-		return r.new(), nil
+	configured := r.new()
+	if algorithm != nil {
+		configured = *algorithm
+	}
+
+	configured, err = r.configure(ctx, key, configured)
+	if err != nil {
+		return defaultAlg, err
 	}
 
-	return *algorithm, nil
+	return configured, nil
 }
 
-func (r RateLimiter[Alg]) Reserve(ctx context.Context, key string, tokens float64) error {
+// cloneAlgorithm copies the algorithm, the changes made to the copy never
+// reach alg. The algorithms of this package implement Cloner, the others are
+// copied with reflect, struct deep only.
+func cloneAlgorithm[Alg Algorithm](alg Alg) Alg {
+	cloner, ok := any(alg).(Cloner[Alg])
+	if ok {
+		return cloner.Clone()
+	}
+
+	value := reflect.ValueOf(alg)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return alg
+	}
+
+	clone := reflect.New(value.Elem().Type())
+	clone.Elem().Set(value.Elem())
+
+	return clone.Interface().(Alg)
+}
+
+func (r RateLimiter[Alg]) update(ctx context.Context, key string, fun func(alg Alg) error) (Alg, error) {
+	atomicStorer, ok := asAtomicStorer(r.algStorer)
+	if ok {
+		return r.atomicUpdate(ctx, atomicStorer, key, fun)
+	}
+
 	algorithm, err := r.loadAlgorithm(ctx, key)
 	if err != nil {
-		return err
+		return algorithm, err
 	}
 
-	err = algorithm.Reserve(tokens)
+	err = fun(algorithm)
 	if err != nil {
-		return fmt.Errorf("can't reserve that capacity: %w", err)
+		return algorithm, err
 	}
 
-	_, err = r.algStorer.Store(ctx, key, algorithm)
+	stored, err := r.algStorer.Store(ctx, key, algorithm)
 	if err != nil {
-		return fmt.Errorf("can't store key status: %w", err)
+		return algorithm, fmt.Errorf("can't store key status: %w", err)
 	}
 
-	return nil
+	return stored, nil
+}
+
+func (r RateLimiter[Alg]) atomicUpdate(
+	ctx context.Context,
+	atomicStorer AtomicStorer[Alg],
+	key string,
+	fun func(alg Alg) error,
+) (Alg, error) {
+	var funErr error
+
+	stored, err := atomicStorer.Update(ctx, key, func(alg *Alg) (Alg, error) {
+		algorithm := r.new()
+		if alg != nil {
+			algorithm = *alg
+		}
+
+		algorithm, funErr = r.configure(ctx, key, algorithm)
+		if funErr != nil {
+			return algorithm, funErr
+		}
+
+		funErr = fun(algorithm)
+		return algorithm, funErr
+	})
+
+	//fun can be called again on conflicts, only its last result matters
+	if funErr != nil {
+		return stored, funErr
+	}
+
+	if err != nil {
+		return stored, fmt.Errorf("can't update key status: %w", err)
+	}
+
+	return stored, nil
+}
+
+func (r RateLimiter[Alg]) Reserve(ctx context.Context, key string, tokens float64) error {
+	err := r.reserve(ctx, key, tokens)
+	return r.dryRunError(ctx, key, tokens, err)
+}
+
+func (r RateLimiter[Alg]) reserve(ctx context.Context, key string, tokens float64) error {
+	reserveStorer, ok := r.algStorer.(ReserveStorer[Alg])
+	if ok {
+		algorithm, err := r.configure(ctx, key, r.new())
+		if err != nil {
+			return err
+		}
+
+		err = reserveStorer.Reserve(ctx, key, algorithm, tokens)
+		if err != nil {
+			return fmt.Errorf("can't reserve that capacity: %w", err)
+		}
+
+		return nil
+	}
+
+	_, err := r.update(ctx, key, func(algorithm Alg) error {
+		err := algorithm.Reserve(tokens)
+		if err != nil {
+			return fmt.Errorf("can't reserve that capacity: %w", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (r RateLimiter[Alg]) Refund(ctx context.Context, key string, tokens float64) error {
+	//a refund lowers the sort value, the storers without atomic updates
+	//would discard it as a stale write
+	atomicStorer, ok := asAtomicStorer(r.algStorer)
+	if !ok {
+		return fmt.Errorf("can't refund tokens without an atomic storer: %w", ErrNotSupported)
+	}
+
+	_, err := r.atomicUpdate(ctx, atomicStorer, key, func(algorithm Alg) error {
+		refunder, ok := any(algorithm).(Refunder)
+		if !ok {
+			return fmt.Errorf("can't refund tokens: %w", ErrNotSupported)
+		}
+
+		err := refunder.Refund(tokens)
+		if err != nil {
+			return fmt.Errorf("can't refund tokens: %w", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Drain leaves the key without tokens until retryAfter, every replica sharing
+// the store waits for it
+func (r RateLimiter[Alg]) Drain(ctx context.Context, key string, retryAfter time.Duration) error {
+	_, err := r.update(ctx, key, func(algorithm Alg) error {
+		drainer, ok := any(algorithm).(Drainer)
+		if !ok {
+			return fmt.Errorf("can't drain tokens: %w", ErrNotSupported)
+		}
+
+		err := drainer.Drain(retryAfter)
+		if err != nil {
+			return fmt.Errorf("can't drain tokens: %w", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (r RateLimiter[Alg]) Status(ctx context.Context, key string, tokens float64) (Status, error) {
+	algorithm, err := r.loadAlgorithm(ctx, key)
+	if err != nil {
+		return Status{}, err
+	}
+
+	inspector, ok := any(algorithm).(Inspector)
+	if !ok {
+		return Status{}, fmt.Errorf("can't inspect key status: %w", ErrNotSupported)
+	}
+
+	return inspector.Inspect(tokens), nil
+}
+
+func (r RateLimiter[Alg]) maxDelay(ctx context.Context) time.Duration {
+	maxDelay := r.maxQueueDelay
+
+	deadline, ok := ctx.Deadline()
+	if ok && time.Until(deadline) < maxDelay {
+		maxDelay = time.Until(deadline)
+	}
+
+	return maxDelay
+}
+
+func (r RateLimiter[Alg]) reserveWithDelay(ctx context.Context, key string, tokens float64) (time.Duration, error) {
+	var delay time.Duration
+
+	_, err := r.update(ctx, key, func(algorithm Alg) error {
+		var err error
+
+		delayed, ok := any(algorithm).(DelayedAlgorithm)
+		if ok {
+			delay, err = delayed.ReserveWithDelay(tokens, r.maxDelay(ctx))
+		} else {
+			err = algorithm.Reserve(tokens)
+		}
+
+		if err != nil {
+			return fmt.Errorf("can't reserve that capacity: %w", err)
+		}
+
+		return nil
+	})
+
+	return delay, err
+}
+
+// Wait reserves the tokens and waits for them to be available. When ctx is
+// done while waiting for a reserved slot, the tokens are refunded if the
+// storer and the algorithm support it, otherwise they stay spent.
+func (r RateLimiter[Alg]) Wait(ctx context.Context, key string, tokens float64) error {
+	if r.dryRun != nil {
+		//waiting would slow down the requests that the dry run must let through
+		return r.Reserve(ctx, key, tokens)
+	}
+
+	var zero Alg
+	_, delayed := any(zero).(DelayedAlgorithm)
+
+	for {
+		delay, err := r.reserveWithDelay(ctx, key, tokens)
+
+		var tooManyReqErr ErrTooManyRequests
+		if !delayed && errors.As(err, &tooManyReqErr) && tooManyReqErr.RetryAfter <= r.maxDelay(ctx) {
+			//the algorithm can't reserve in the future, retry once it can accept
+			err = sleep(ctx, tooManyReqErr.RetryAfter)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = sleep(ctx, delay)
+		if err != nil {
+			//the caller gave up, the slot can go to someone else
+			refundErr := r.Refund(context.WithoutCancel(ctx), key, tokens)
+			if refundErr != nil && !errors.Is(refundErr, ErrNotSupported) {
+				return errors.Join(err, refundErr)
+			}
+		}
+
+		return err
+	}
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("context expired while waiting: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
 }
@@ -0,0 +1,253 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func requireTooManyRequests(t *testing.T, err error) core.ErrTooManyRequests {
+	t.Helper()
+
+	return testutils.RequireErrorAs[core.ErrTooManyRequests](t, err)
+}
+
+func TestRateLimiter_Wait_DelayQueuedRequests(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.LeakyBucket {
+			return core.NewLeakyBucket(10, 100)
+		},
+		core.NewInMemoryStore[*core.LeakyBucket](10),
+	)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := rateLimiter.Wait(ctx, "key1", 1)
+		testutils.RequireNoError(t, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected to wait at least 30ms, waited %s", elapsed)
+	}
+}
+
+func TestRateLimiter_Wait_RejectIfMaxQueueDelayIsExceeded(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.LeakyBucket {
+			return core.NewLeakyBucket(10, 1)
+		},
+		core.NewInMemoryStore[*core.LeakyBucket](10),
+	).WithMaxQueueDelay(time.Second)
+
+	testutils.RequireNoError(t, rateLimiter.Wait(ctx, "key1", 2))
+
+	start := time.Now()
+	requireTooManyRequests(t, rateLimiter.Wait(ctx, "key1", 1))
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected to be rejected without waiting, waited %s", elapsed)
+	}
+}
+
+func TestRateLimiter_Wait_RejectIfContextDeadlineIsExceeded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.LeakyBucket {
+			return core.NewLeakyBucket(10, 1)
+		},
+		core.NewInMemoryStore[*core.LeakyBucket](10),
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Wait(ctx, "key1", 1))
+	requireTooManyRequests(t, rateLimiter.Wait(ctx, "key1", 1))
+}
+
+func TestRateLimiter_Wait_RefundIfContextIsCancelledWhileWaiting(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	store := core.NewInMemoryStore[*core.LeakyBucket](10)
+	rateLimiter := core.NewRateLimiter(
+		func() *core.LeakyBucket {
+			return core.NewLeakyBucket(10, 1).WitNowProvider(clock.Now)
+		},
+		store,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Wait(context.Background(), "key1", 1))
+
+	//without a deadline the request is queued, and then cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := rateLimiter.Wait(ctx, "key1", 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	//only the slot of the first request is still taken
+	stored, err := store.Load(context.Background(), "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, clock.Now().Add(time.Second), (*stored).NextSlot)
+}
+
+func TestRateLimiter_Wait_RetryAlgorithmsWithoutDelay(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(1, 50)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+
+	start := time.Now()
+	testutils.RequireNoError(t, rateLimiter.Wait(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Wait(ctx, "key1", 1))
+
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("expected to wait at least 15ms, waited %s", elapsed)
+	}
+}
+
+func TestRateLimiter_Reserve_NoLostUpdatesWithAtomicStorer(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(50, 0.001)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rateLimiter.Reserve(ctx, "key1", 1) == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	testutils.RequireEqual(t, int32(50), accepted.Load())
+}
+
+func TestRateLimiter_Refund_GiveBackTokens(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	testutils.RequireNoError(t, rateLimiter.Refund(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+}
+
+func TestRateLimiter_Refund_NotSupported(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.FixedWindow {
+			return core.NewFixedWindow(2, time.Minute)
+		},
+		core.NewInMemoryStore[*core.FixedWindow](10),
+	)
+
+	err := rateLimiter.Refund(ctx, "key1", 1)
+	if !errors.Is(err, core.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestRateLimiter_Status_WithoutConsumingTokens(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 1).WitNowProvider(clock.Now)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+
+	status, err := rateLimiter.Status(ctx, "key1", 1)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, core.Status{Limit: 2, Remaining: 2, ResetAt: clock.Now()}, status)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+
+	for i := 0; i < 2; i++ {
+		status, err = rateLimiter.Status(ctx, "key1", 1)
+		testutils.RequireNoError(t, err)
+		testutils.RequireEqual(t, 0.0, status.Remaining)
+		testutils.RequireEqual(t, time.Second, status.RetryAfter)
+	}
+}
+
+func TestRateLimiter_Status_NotSupported(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.FixedWindow {
+			return core.NewFixedWindow(2, time.Minute)
+		},
+		core.NewInMemoryStore[*core.FixedWindow](10),
+	)
+
+	_, err := rateLimiter.Status(ctx, "key1", 1)
+	if !errors.Is(err, core.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestRateLimiter_WithDryRun_ReportRejections(t *testing.T) {
+	ctx := context.Background()
+	store := core.NewInMemoryStore[*core.TokenBucket](10)
+	newTokenBucket := func() *core.TokenBucket {
+		return core.NewTokenBucket(2, 0.001)
+	}
+
+	var rejected []float64
+	dryRun := core.NewRateLimiter(newTokenBucket, store).WithDryRun(
+		func(ctx context.Context, key string, tokens float64, err core.ErrTooManyRequests) {
+			testutils.RequireEqual(t, "key1", key)
+			rejected = append(rejected, tokens)
+		},
+	)
+
+	testutils.RequireNoError(t, dryRun.Reserve(ctx, "key1", 2))
+	testutils.RequireNoError(t, dryRun.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, dryRun.Wait(ctx, "key1", 2))
+	testutils.RequireElementsMatch(t, []float64{1, 2}, rejected)
+
+	//the state is updated as if the limit was enforced
+	enforced := core.NewRateLimiter(newTokenBucket, store)
+	requireTooManyRequests(t, enforced.Reserve(ctx, "key1", 1))
+}
+
+func TestLogDryRun(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buffer, nil))
+
+	core.LogDryRun(logger)(context.Background(), "key1", 2, core.ErrTooManyRequests{RetryAfter: time.Second})
+
+	if !strings.Contains(buffer.String(), "key=key1 tokens=2 retryAfter=1s") {
+		t.Errorf("unexpected log %s", buffer.String())
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Reservation[Alg Algorithm] struct {
+	rateLimiter  RateLimiter[Alg]
+	atomicStorer AtomicStorer[Alg]
+	key          string
+	id           string
+	ok           bool
+	delay        time.Duration
+	done         bool
+}
+
+func (r RateLimiter[Alg]) Hold(ctx context.Context, key string, tokens float64) (*Reservation[Alg], error) {
+	//commit and cancel can lower the sort value, the storers without atomic
+	//updates would discard them as stale writes
+	atomicStorer, ok := asAtomicStorer(r.algStorer)
+	if !ok {
+		return nil, fmt.Errorf("can't hold tokens without an atomic storer: %w", ErrNotSupported)
+	}
+
+	id := newLeaseID()
+
+	_, err := r.atomicUpdate(ctx, atomicStorer, key, func(algorithm Alg) error {
+		holder, ok := any(algorithm).(Holder)
+		if !ok {
+			return fmt.Errorf("can't hold tokens: %w", ErrNotSupported)
+		}
+
+		err := holder.Hold(id, tokens, r.holdTimeout)
+		if err != nil {
+			return fmt.Errorf("can't hold that capacity: %w", err)
+		}
+
+		return nil
+	})
+
+	var tooManyReqErr ErrTooManyRequests
+	if errors.As(err, &tooManyReqErr) {
+		return &Reservation[Alg]{delay: tooManyReqErr.RetryAfter}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reservation[Alg]{
+		rateLimiter:  r,
+		atomicStorer: atomicStorer,
+		key:          key,
+		id:           id,
+		ok:           true,
+	}, nil
+}
+
+// OK reports whether the tokens are held
+func (res *Reservation[Alg]) OK() bool {
+	return res.ok
+}
+
+// Delay is how long to wait before holding the tokens again when the
+// reservation isn't OK, it is zero otherwise
+func (res *Reservation[Alg]) Delay() time.Duration {
+	return res.delay
+}
+
+func (res *Reservation[Alg]) Commit(ctx context.Context) error {
+	return res.finish(ctx, func(holder Holder) error {
+		return holder.Commit(res.id)
+	})
+}
+
+func (res *Reservation[Alg]) Cancel(ctx context.Context) error {
+	return res.finish(ctx, func(holder Holder) error {
+		return holder.Cancel(res.id)
+	})
+}
+
+func (res *Reservation[Alg]) finish(ctx context.Context, fun func(holder Holder) error) error {
+	if !res.ok || res.done {
+		return nil
+	}
+
+	_, err := res.rateLimiter.atomicUpdate(ctx, res.atomicStorer, res.key, func(algorithm Alg) error {
+		return fun(any(algorithm).(Holder))
+	})
+	if err != nil {
+		return err
+	}
+
+	res.done = true
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func newHoldRateLimiter(clock *testutils.Clock) core.RateLimiter[*core.TokenBucket] {
+	return core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001).WitNowProvider(clock.Now)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	).WithHoldTimeout(time.Minute)
+}
+
+func TestRateLimiter_Hold_Commit(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	rateLimiter := newHoldRateLimiter(clock)
+
+	reservation, err := rateLimiter.Hold(ctx, "key1", 2)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, true, reservation.OK())
+	testutils.RequireEqual(t, time.Duration(0), reservation.Delay())
+
+	testutils.RequireNoError(t, reservation.Commit(ctx))
+	//committing twice and cancelling afterwards have no effect
+	testutils.RequireNoError(t, reservation.Commit(ctx))
+	testutils.RequireNoError(t, reservation.Cancel(ctx))
+
+	clock.Advance(2 * time.Minute)
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+}
+
+func TestRateLimiter_Hold_Cancel(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	rateLimiter := newHoldRateLimiter(clock)
+
+	reservation, err := rateLimiter.Hold(ctx, "key1", 2)
+	testutils.RequireNoError(t, err)
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	testutils.RequireNoError(t, reservation.Cancel(ctx))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+}
+
+func TestRateLimiter_Hold_ExpireWithoutCommit(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	rateLimiter := newHoldRateLimiter(clock)
+
+	_, err := rateLimiter.Hold(ctx, "key1", 2)
+	testutils.RequireNoError(t, err)
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	clock.Advance(time.Minute)
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+}
+
+func TestRateLimiter_Hold_CommitAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	rateLimiter := newHoldRateLimiter(clock)
+
+	reservation, err := rateLimiter.Hold(ctx, "key1", 2)
+	testutils.RequireNoError(t, err)
+
+	clock.Advance(time.Minute)
+	err = reservation.Commit(ctx)
+	if !errors.Is(err, core.ErrHoldExpired) {
+		t.Fatalf("expected ErrHoldExpired, got %v", err)
+	}
+
+	//the tokens went back to the bucket when the hold expired
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+}
+
+// forgetfulStore loses the keys, as a store whose items expired
+type forgetfulStore struct {
+	*core.InMmemoryStore[*core.TokenBucket]
+}
+
+func (s forgetfulStore) Update(
+	ctx context.Context,
+	key string,
+	fun func(alg **core.TokenBucket) (*core.TokenBucket, error),
+) (*core.TokenBucket, error) {
+	return s.InMmemoryStore.Update(ctx, key, func(_ **core.TokenBucket) (*core.TokenBucket, error) {
+		return fun(nil)
+	})
+}
+
+func TestRateLimiter_Hold_CancelMissingKey(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	store := forgetfulStore{core.NewInMemoryStore[*core.TokenBucket](10)}
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001).WitNowProvider(clock.Now)
+		},
+		store,
+	)
+
+	reservation, err := rateLimiter.Hold(ctx, "key1", 2)
+	testutils.RequireNoError(t, err)
+
+	err = reservation.Cancel(ctx)
+	if !errors.Is(err, core.ErrHoldNotFound) {
+		t.Fatalf("expected ErrHoldNotFound, got %v", err)
+	}
+
+	//no fresh bucket replaced the stored one
+	stored, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 1, len((*stored).Holds))
+}
+
+func TestRateLimiter_Hold_NotOK(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	rateLimiter := newHoldRateLimiter(clock)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+
+	reservation, err := rateLimiter.Hold(ctx, "key1", 1)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, false, reservation.OK())
+	testutils.RequireEqual(t, 1000*time.Second, reservation.Delay())
+	testutils.RequireNoError(t, reservation.Cancel(ctx))
+}
+
+func TestRateLimiter_Hold_NotSupported(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := core.NewRateLimiter(
+		func() *core.FixedWindow {
+			return core.NewFixedWindow(2, time.Minute)
+		},
+		core.NewInMemoryStore[*core.FixedWindow](10),
+	)
+
+	_, err := rateLimiter.Hold(ctx, "key1", 1)
+	if !errors.Is(err, core.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// ResilientStore protects the rate limiter from the failures of actualStore.
+// After failureThreshold consecutive failures the circuit breaker opens and
+// the keys are served by a local InMmemoryStore, until a trial call to
+// actualStore succeeds once openDuration is over. The failures that happen
+// while the breaker is closed are served by the local store too when the
+// store fails open, and are rejected with ErrStoreUnavailable otherwise.
+type ResilientStore[T Algorithm] struct {
+	logger           *slog.Logger
+	actualStore      AlgorithmStorer[T]
+	fallback         *InMmemoryStore[T]
+	fallbackSize     int
+	failOpen         bool
+	failureThreshold int
+	openDuration     time.Duration
+	lock             sync.Mutex
+	state            breakerState
+	failures         int
+	openedAt         time.Time
+	trialInFlight    bool
+	nowProvider      func() time.Time
+}
+
+var _ AlgorithmStorer[*TokenBucket] = &ResilientStore[*TokenBucket]{}
+var _ AtomicStorer[*TokenBucket] = &ResilientStore[*TokenBucket]{}
+var _ ReserveStorer[*TokenBucket] = &ResilientStore[*TokenBucket]{}
+
+// ErrStoreUnavailable is returned when the store fails closed
+var ErrStoreUnavailable = errors.New("store unavailable")
+
+// ErrBreakerOpen is returned when the failure of a store failing closed opens
+// the breaker, it wraps ErrStoreUnavailable and the failure of the store
+type ErrBreakerOpen struct {
+	RetryAfter time.Duration //how long the breaker stays open
+	Err        error
+}
+
+func (e ErrBreakerOpen) Error() string {
+	return fmt.Sprintf("%s, breaker open for %s: %s", ErrStoreUnavailable, e.RetryAfter, e.Err)
+}
+
+func (e ErrBreakerOpen) Unwrap() []error {
+	return []error{ErrStoreUnavailable, e.Err}
+}
+
+func NewResilientStore[T Algorithm](
+	logger *slog.Logger,
+	actualStore AlgorithmStorer[T],
+	fallbackSize int,
+) *ResilientStore[T] {
+	return &ResilientStore[T]{
+		logger:           logger,
+		actualStore:      actualStore,
+		fallback:         NewInMemoryStore[T](fallbackSize),
+		fallbackSize:     fallbackSize,
+		failureThreshold: 5,
+		openDuration:     30 * time.Second,
+		nowProvider:      time.Now,
+	}
+}
+
+func (store *ResilientStore[T]) WithFailOpen(failOpen bool) *ResilientStore[T] {
+	store.failOpen = failOpen
+	return store
+}
+
+func (store *ResilientStore[T]) WithBreaker(failureThreshold int, openDuration time.Duration) *ResilientStore[T] {
+	store.failureThreshold = failureThreshold
+	store.openDuration = openDuration
+	return store
+}
+
+func (store *ResilientStore[T]) setState(state breakerState, err error) {
+	if store.state == state {
+		return
+	}
+
+	store.state = state
+	if state == breakerOpen {
+		store.openedAt = store.nowProvider()
+		store.logger.Warn("store circuit breaker opened", "failures", store.failures, "error", err)
+	} else {
+		store.logger.Info("store circuit breaker state changed", "state", state.String())
+	}
+}
+
+// allow tells if the call can go to the actual store
+func (store *ResilientStore[T]) allow() bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.state == breakerOpen && store.nowProvider().Sub(store.openedAt) >= store.openDuration {
+		store.setState(breakerHalfOpen, nil)
+	}
+
+	switch store.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		//a single trial call at a time
+		if store.trialInFlight {
+			return false
+		}
+
+		store.trialInFlight = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (store *ResilientStore[T]) record(err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.trialInFlight = false
+
+	if err == nil {
+		if store.state != breakerClosed {
+			//the local state is stale once the actual store is back
+			store.fallback = NewInMemoryStore[T](store.fallbackSize)
+		}
+
+		store.failures = 0
+		store.setState(breakerClosed, nil)
+		return
+	}
+
+	store.failures++
+	if store.state == breakerHalfOpen || store.failures >= store.failureThreshold {
+		store.setState(breakerOpen, err)
+	}
+}
+
+func (store *ResilientStore[T]) releaseTrial() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.trialInFlight = false
+}
+
+func (store *ResilientStore[T]) fallbackStore() *InMmemoryStore[T] {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.fallback
+}
+
+// the errors of the update functions, they aren't failures of the store
+type callerError struct {
+	err error
+}
+
+func (e callerError) Error() string {
+	return e.err.Error()
+}
+
+func unwrapCallerError[R any](result R, err error) (R, error) {
+	var callerErr callerError
+	if errors.As(err, &callerErr) {
+		return result, callerErr.err
+	}
+
+	return result, err
+}
+
+// call runs fun on the actual store when the breaker allows it, and on the
+// fallback store otherwise
+func call[T Algorithm, R any](
+	ctx context.Context,
+	store *ResilientStore[T],
+	fun func(actualStore AlgorithmStorer[T]) (R, error),
+) (R, error) {
+	if !store.allow() {
+		return unwrapCallerError(fun(store.fallbackStore()))
+	}
+
+	result, err := fun(store.actualStore)
+
+	//the caller gave up, the store isn't to blame
+	if err != nil && ctx.Err() != nil {
+		store.releaseTrial()
+		return result, err
+	}
+
+	//the update function failed, the store worked
+	if err == nil || errors.As(err, &callerError{}) {
+		store.record(nil)
+		return unwrapCallerError(result, err)
+	}
+
+	store.record(err)
+
+	if store.failOpen {
+		return unwrapCallerError(fun(store.fallbackStore()))
+	}
+
+	store.logger.Debug("store failed closed", "error", err)
+
+	//not a rejection of the request, the callers must not take it as one
+	retryAfter := store.retryAfter()
+	if retryAfter > 0 {
+		return result, ErrBreakerOpen{RetryAfter: retryAfter, Err: err}
+	}
+
+	return result, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+}
+
+// retryAfter is how long the breaker stays open, zero if it is closed
+func (store *ResilientStore[T]) retryAfter() time.Duration {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.state != breakerOpen {
+		return 0
+	}
+
+	return store.openedAt.Add(store.openDuration).Sub(store.nowProvider())
+}
+
+func (store *ResilientStore[T]) Store(ctx context.Context, key string, alg T) (T, error) {
+	return call(ctx, store, func(actualStore AlgorithmStorer[T]) (T, error) {
+		return actualStore.Store(ctx, key, alg)
+	})
+}
+
+func (store *ResilientStore[T]) Load(ctx context.Context, key string) (*T, error) {
+	return call(ctx, store, func(actualStore AlgorithmStorer[T]) (*T, error) {
+		return actualStore.Load(ctx, key)
+	})
+}
+
+func (store *ResilientStore[T]) atomic() bool {
+	_, ok := asAtomicStorer(store.actualStore)
+	return ok
+}
+
+func (store *ResilientStore[T]) Update(ctx context.Context, key string, fun func(alg *T) (T, error)) (T, error) {
+	//a load and a store would let Refund and Hold overwrite concurrent updates
+	if !store.atomic() {
+		return *new(T), fmt.Errorf("can't update without an atomic actual store: %w", ErrNotSupported)
+	}
+
+	callerFun := func(alg *T) (T, error) {
+		result, err := fun(alg)
+		if err != nil {
+			return result, callerError{err: err}
+		}
+
+		return result, nil
+	}
+
+	return call(ctx, store, func(actualStore AlgorithmStorer[T]) (T, error) {
+		return actualStore.(AtomicStorer[T]).Update(ctx, key, callerFun)
+	})
+}
+
+// Reserve forwards to the actual store when it reserves on its side, as the
+// redis one does, and reserves with an update or a load and a store otherwise
+func (store *ResilientStore[T]) Reserve(ctx context.Context, key string, alg T, tokens float64) error {
+	_, err := call(ctx, store, func(actualStore AlgorithmStorer[T]) (struct{}, error) {
+		reserveStorer, ok := actualStore.(ReserveStorer[T])
+		if !ok {
+			return struct{}{}, reserveOn(ctx, actualStore, key, alg, tokens)
+		}
+
+		err := reserveStorer.Reserve(ctx, key, alg, tokens)
+		//the store worked when it rejects the request
+		if errors.As(err, &ErrTooManyRequests{}) || errors.Is(err, ErrOutOfBoundsRequest) {
+			return struct{}{}, callerError{err: err}
+		}
+
+		return struct{}{}, err
+	})
+
+	return err
+}
+
+func reserveOn[T Algorithm](ctx context.Context, actualStore AlgorithmStorer[T], key string, alg T, tokens float64) error {
+	reserve := func(current *T) (T, error) {
+		algorithm, err := reserveOnCurrent(current, alg, tokens)
+		if err != nil {
+			return algorithm, callerError{err: err}
+		}
+
+		return algorithm, nil
+	}
+
+	atomicStorer, ok := asAtomicStorer(actualStore)
+	if ok {
+		_, err := atomicStorer.Update(ctx, key, reserve)
+		return err
+	}
+
+	current, err := actualStore.Load(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	algorithm, err := reserve(current)
+	if err != nil {
+		return err
+	}
+
+	_, err = actualStore.Store(ctx, key, algorithm)
+	return err
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+var errStoreDown = errors.New("store down")
+
+type flakyStorer struct {
+	*InMmemoryStore[*TokenBucket]
+	down  bool
+	calls int
+}
+
+func (store *flakyStorer) Load(ctx context.Context, key string) (**TokenBucket, error) {
+	store.calls++
+	if store.down {
+		return nil, errStoreDown
+	}
+
+	return store.InMmemoryStore.Load(ctx, key)
+}
+
+func (store *flakyStorer) Update(
+	ctx context.Context,
+	key string,
+	fun func(alg **TokenBucket) (*TokenBucket, error),
+) (*TokenBucket, error) {
+	store.calls++
+	if store.down {
+		return nil, errStoreDown
+	}
+
+	return store.InMmemoryStore.Update(ctx, key, fun)
+}
+
+func newResilientRateLimiter(clock *testutils.Clock, failOpen bool) (RateLimiter[*TokenBucket], *flakyStorer) {
+	actualStore := &flakyStorer{InMmemoryStore: NewInMemoryStore[*TokenBucket](10)}
+
+	store := NewResilientStore[*TokenBucket](testutils.NewNoOpLogger(), actualStore, 10).
+		WithFailOpen(failOpen).
+		WithBreaker(2, time.Minute)
+	store.nowProvider = clock.Now
+
+	rateLimiter := NewRateLimiter(
+		func() *TokenBucket {
+			return NewTokenBucket(2, 0.001)
+		},
+		store,
+	)
+
+	return rateLimiter, actualStore
+}
+
+func TestResilientStore_FailClosed(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	rateLimiter, actualStore := newResilientRateLimiter(clock, false)
+	actualStore.down = true
+
+	//there is no delay to suggest before the breaker opens
+	err := rateLimiter.Reserve(ctx, "key1", 1)
+	if !errors.Is(err, ErrStoreUnavailable) || errors.As(err, &ErrTooManyRequests{}) {
+		t.Fatalf("expected ErrStoreUnavailable, got %v", err)
+	}
+
+	//the failure that opens the breaker isn't a rejection of the request
+	err = rateLimiter.Reserve(ctx, "key1", 1)
+	breakerErr := testutils.RequireErrorAs[ErrBreakerOpen](t, err)
+	testutils.RequireEqual(t, time.Minute, breakerErr.RetryAfter)
+	if !errors.Is(err, ErrStoreUnavailable) || errors.As(err, &ErrTooManyRequests{}) {
+		t.Fatalf("expected ErrStoreUnavailable, got %v", err)
+	}
+}
+
+func TestResilientStore_FailClosed_NotSwallowedByDryRun(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	rateLimiter, actualStore := newResilientRateLimiter(clock, false)
+	rateLimiter = rateLimiter.WithDryRun(func(ctx context.Context, key string, tokens float64, err ErrTooManyRequests) {
+		t.Fatalf("unexpected dry run rejection %v", err)
+	})
+	actualStore.down = true
+
+	for i := 0; i < 2; i++ {
+		err := rateLimiter.Reserve(ctx, "key1", 1)
+		if !errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("expected ErrStoreUnavailable, got %v", err)
+		}
+	}
+}
+
+func TestResilientStore_FailOpen(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	rateLimiter, actualStore := newResilientRateLimiter(clock, true)
+	actualStore.down = true
+
+	//the local store still enforces the limit
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+	err := rateLimiter.Reserve(ctx, "key1", 1)
+	if !errors.As(err, &ErrTooManyRequests{}) {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+}
+
+func TestResilientStore_Breaker(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	rateLimiter, actualStore := newResilientRateLimiter(clock, true)
+
+	actualStore.down = true
+	for i := 0; i < 4; i++ {
+		_ = rateLimiter.Reserve(ctx, "key1", 1)
+	}
+	//the actual store isn't called while the breaker is open
+	testutils.RequireEqual(t, 2, actualStore.calls)
+
+	clock.Advance(time.Minute)
+	_ = rateLimiter.Reserve(ctx, "key1", 1)
+	_ = rateLimiter.Reserve(ctx, "key1", 1)
+	testutils.RequireEqual(t, 3, actualStore.calls)
+
+	actualStore.down = false
+	clock.Advance(time.Minute)
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireEqual(t, 5, actualStore.calls)
+}
+
+func TestResilientStore_AlgorithmErrorIsNotAFailure(t *testing.T) {
+	ctx := context.Background()
+	clock := testutils.NewClock(newTimeAt(1))
+	rateLimiter, actualStore := newResilientRateLimiter(clock, false)
+
+	for i := 0; i < 5; i++ {
+		err := rateLimiter.Reserve(ctx, "key1", 5)
+		if !errors.Is(err, ErrOutOfBoundsRequest) {
+			t.Fatalf("expected ErrOutOfBoundsRequest, got %v", err)
+		}
+	}
+
+	testutils.RequireEqual(t, 5, actualStore.calls)
+}
+
+// loadStoreOnly hides the atomic update of the wrapped store
+type loadStoreOnly struct {
+	AlgorithmStorer[*TokenBucket]
+}
+
+func TestResilientStore_Update_NotSupportedWithoutAtomicStore(t *testing.T) {
+	ctx := context.Background()
+	actualStore := loadStoreOnly{NewInMemoryStore[*TokenBucket](10)}
+	store := NewResilientStore[*TokenBucket](testutils.NewNoOpLogger(), actualStore, 10)
+
+	rateLimiter := NewRateLimiter(
+		func() *TokenBucket {
+			return NewTokenBucket(2, 0.001)
+		},
+		store,
+	)
+
+	//reserve still works with a load and a store
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 2))
+	testutils.RequireErrorAs[ErrTooManyRequests](t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	err := rateLimiter.Refund(ctx, "key1", 1)
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+
+	_, err = store.Update(ctx, "key1", func(alg **TokenBucket) (*TokenBucket, error) {
+		t.Fatal("the update function must not be called")
+		return nil, nil
+	})
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+type serverSideStorer struct {
+	*InMmemoryStore[*TokenBucket]
+	reserves int
+}
+
+func (store *serverSideStorer) Reserve(ctx context.Context, key string, alg *TokenBucket, tokens float64) error {
+	store.reserves++
+	return ErrTooManyRequests{RetryAfter: time.Second}
+}
+
+func TestResilientStore_Reserve_ForwardToReserveStorer(t *testing.T) {
+	ctx := context.Background()
+	actualStore := &serverSideStorer{InMmemoryStore: NewInMemoryStore[*TokenBucket](10)}
+	store := NewResilientStore[*TokenBucket](testutils.NewNoOpLogger(), actualStore, 10).
+		WithBreaker(2, time.Minute)
+
+	rateLimiter := NewRateLimiter(
+		func() *TokenBucket {
+			return NewTokenBucket(2, 0.001)
+		},
+		store,
+	)
+
+	//the rejections of the store don't open the breaker
+	for i := 0; i < 5; i++ {
+		err := testutils.RequireErrorAs[ErrTooManyRequests](t, rateLimiter.Reserve(ctx, "key1", 1))
+		testutils.RequireEqual(t, time.Second, err.RetryAfter)
+	}
+
+	testutils.RequireEqual(t, 5, actualStore.reserves)
+}
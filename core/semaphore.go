@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+type Lease struct {
+	ID       string
+	Slots    float64
+	ExpireAt time.Time
+}
+
+type Semaphore struct {
+	Limit         float64
+	LeaseDuration time.Duration
+	Leases        []Lease
+	Version       int64
+	nowProvider   func() time.Time //for test
+}
+
+var _ Algorithm = &Semaphore{}
+var _ Cloner[*Semaphore] = &Semaphore{}
+
+func NewSemaphore(limit float64, leaseDuration time.Duration) *Semaphore {
+	return &Semaphore{
+		Limit:         limit,
+		LeaseDuration: leaseDuration,
+	}
+}
+
+func (s *Semaphore) WitNowProvider(fun func() time.Time) *Semaphore {
+	s.nowProvider = fun
+	return s
+}
+
+func (s *Semaphore) now() time.Time {
+	if s.nowProvider != nil {
+		return s.nowProvider()
+	} else {
+		return time.Now()
+	}
+}
+
+func (s *Semaphore) removeExpired(now time.Time) {
+	s.Leases = slices.DeleteFunc(s.Leases, func(lease Lease) bool {
+		return !lease.ExpireAt.After(now)
+	})
+}
+
+func (s *Semaphore) usedSlots() float64 {
+	used := 0.0
+	for _, lease := range s.Leases {
+		used += lease.Slots
+	}
+
+	return used
+}
+
+func (s *Semaphore) howMuchToWaitFor(now time.Time, slots float64) time.Duration {
+	leases := slices.Clone(s.Leases)
+	slices.SortFunc(leases, func(a, b Lease) int {
+		return a.ExpireAt.Compare(b.ExpireAt)
+	})
+
+	exceeding := s.usedSlots() + slots - s.Limit
+	for _, lease := range leases {
+		exceeding -= lease.Slots
+		if exceeding <= 0 {
+			return lease.ExpireAt.Sub(now)
+		}
+	}
+
+	return 0
+}
+
+func (s *Semaphore) Acquire(id string, slots float64) error {
+	if slots > s.Limit {
+		return fmt.Errorf("can't acquire more than %f slots:%w", s.Limit, ErrOutOfBoundsRequest)
+	}
+
+	now := s.now()
+	s.removeExpired(now)
+
+	if s.usedSlots()+slots > s.Limit {
+		return ErrTooManyRequests{RetryAfter: s.howMuchToWaitFor(now, slots)}
+	}
+
+	s.Leases = append(s.Leases, Lease{
+		ID:       id,
+		Slots:    slots,
+		ExpireAt: now.Add(s.LeaseDuration),
+	})
+	s.Version++
+
+	return nil
+}
+
+func (s *Semaphore) Release(id string) {
+	s.removeExpired(s.now())
+	s.Leases = slices.DeleteFunc(s.Leases, func(lease Lease) bool {
+		return lease.ID == id
+	})
+	s.Version++
+}
+
+func (s *Semaphore) HasLease(id string) bool {
+	return slices.ContainsFunc(s.Leases, func(lease Lease) bool {
+		return lease.ID == id
+	})
+}
+
+func (s *Semaphore) Reserve(tokens float64) error {
+	//anonymous slots are released only when their lease expires
+	return s.Acquire(newLeaseID(), tokens)
+}
+
+func (s *Semaphore) SortValue() string {
+	return fmt.Sprintf("%020d", s.Version)
+}
+
+func (s *Semaphore) ExpireAt() time.Time {
+	expireAt := s.now()
+	for _, lease := range s.Leases {
+		if lease.ExpireAt.After(expireAt) {
+			expireAt = lease.ExpireAt
+		}
+	}
+
+	return expireAt
+}
+
+func (s *Semaphore) Clone() *Semaphore {
+	clone := *s
+	clone.Leases = slices.Clone(s.Leases)
+	return &clone
+}
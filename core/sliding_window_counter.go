@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type SlidingWindowCounter struct {
+	Limit       float64
+	Window      time.Duration
+	WindowStart time.Time
+	Current     float64
+	Previous    float64
+	nowProvider func() time.Time //for test
+}
+
+var _ CheckedAlgorithm = &SlidingWindowCounter{}
+var _ Configurable = &SlidingWindowCounter{}
+var _ Cloner[*SlidingWindowCounter] = &SlidingWindowCounter{}
+
+func NewSlidingWindowCounter(limit float64, window time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		Limit:       limit,
+		Window:      window,
+		WindowStart: time.Now().Truncate(window),
+	}
+}
+
+func (swc *SlidingWindowCounter) WitNowProvider(fun func() time.Time) *SlidingWindowCounter {
+	swc.WindowStart = fun().Truncate(swc.Window)
+	swc.nowProvider = fun
+	return swc
+}
+
+func (swc *SlidingWindowCounter) now() time.Time {
+	if swc.nowProvider != nil {
+		return swc.nowProvider()
+	} else {
+		return time.Now()
+	}
+}
+
+func (swc *SlidingWindowCounter) advance(now time.Time) {
+	windowStart := now.Truncate(swc.Window)
+	if !windowStart.After(swc.WindowStart) {
+		return
+	}
+
+	if windowStart.Equal(swc.WindowStart.Add(swc.Window)) {
+		swc.Previous = swc.Current
+	} else {
+		swc.Previous = 0
+	}
+
+	swc.Current = 0
+	swc.WindowStart = windowStart
+}
+
+func (swc *SlidingWindowCounter) estimate(now time.Time) float64 {
+	elapsed := now.Sub(swc.WindowStart)
+	previousWeight := 1 - float64(elapsed)/float64(swc.Window)
+	return swc.Previous*previousWeight + swc.Current
+}
+
+func (swc *SlidingWindowCounter) howMuchToWaitFor(now time.Time, tokens float64) time.Duration {
+	var waitUntil time.Time
+
+	if swc.Current+tokens <= swc.Limit {
+		//enough of the previous window has to slide out
+		previousWeight := (swc.Limit - swc.Current - tokens) / swc.Previous
+		elapsed := math.Round(float64(swc.Window) * (1 - previousWeight))
+		waitUntil = swc.WindowStart.Add(time.Duration(elapsed))
+	} else {
+		//the current window becomes the previous one and has to slide out
+		previousWeight := (swc.Limit - tokens) / swc.Current
+		elapsed := math.Round(float64(swc.Window) * (1 - previousWeight))
+		waitUntil = swc.WindowStart.Add(swc.Window + time.Duration(elapsed))
+	}
+
+	return waitUntil.Sub(now)
+}
+
+func (swc *SlidingWindowCounter) Reserve(tokens float64) error {
+	if tokens > swc.Limit {
+		return fmt.Errorf("can't reserve more than %f tokens:%w", swc.Limit, ErrOutOfBoundsRequest)
+	}
+
+	now := swc.now()
+	swc.advance(now)
+
+	if swc.estimate(now)+tokens > swc.Limit {
+		return ErrTooManyRequests{RetryAfter: swc.howMuchToWaitFor(now, tokens)}
+	}
+
+	swc.Current += tokens
+
+	return nil
+}
+
+func (swc *SlidingWindowCounter) Check(tokens float64) error {
+	//reserve on a copy to leave the state untouched
+	clone := *swc
+	return clone.Reserve(tokens)
+}
+
+func (swc *SlidingWindowCounter) Config() AlgorithmConfig {
+	return AlgorithmConfig{Burst: swc.Limit, Window: swc.Window}
+}
+
+func (swc *SlidingWindowCounter) Configure(config AlgorithmConfig) error {
+	if config.Burst > 0 {
+		swc.Limit = config.Burst
+	}
+
+	if config.Window > 0 && config.Window != swc.Window {
+		//the tokens spent so far count in the first window of the new size
+		now := swc.now()
+		swc.advance(now)
+		spent := swc.estimate(now)
+
+		swc.Window = config.Window
+		swc.WindowStart = now.Truncate(swc.Window)
+		swc.Current = spent
+		swc.Previous = 0
+	}
+
+	return nil
+}
+
+func (swc *SlidingWindowCounter) Clone() *SlidingWindowCounter {
+	clone := *swc
+	return &clone
+}
+
+func (swc *SlidingWindowCounter) SortValue() string {
+	return sortValue(swc.WindowStart, swc.Current)
+}
+
+func (swc *SlidingWindowCounter) ExpireAt() time.Time {
+	switch {
+	case swc.Current > 0:
+		return swc.WindowStart.Add(2 * swc.Window)
+	case swc.Previous > 0:
+		return swc.WindowStart.Add(swc.Window)
+	default:
+		return swc.now()
+	}
+}
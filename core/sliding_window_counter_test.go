@@ -0,0 +1,101 @@
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestSlidingWindowCounter_Reserve_RejectWhenCurrentWindowIsFull(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swc := core.NewSlidingWindowCounter(10, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swc.Reserve(10))
+
+	clock.Advance(30 * time.Second)
+	err := requireTooManyRequests(t, swc.Reserve(5))
+
+	//at 1:30 of the next window the previous one weighs 50%
+	testutils.RequireEqual(t, time.Minute, err.RetryAfter)
+
+	clock.Advance(err.RetryAfter)
+	testutils.RequireNoError(t, swc.Reserve(5))
+}
+
+func TestSlidingWindowCounter_Reserve_WeightPreviousWindow(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swc := core.NewSlidingWindowCounter(10, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swc.Reserve(10))
+
+	clock.Advance(time.Minute + 15*time.Second)
+	//previous window weighs 75%
+	testutils.RequireNoError(t, swc.Reserve(2))
+	err := requireTooManyRequests(t, swc.Reserve(1))
+
+	//the previous window has to weigh 70%
+	testutils.RequireEqual(t, 3*time.Second, err.RetryAfter)
+
+	clock.Advance(err.RetryAfter)
+	testutils.RequireNoError(t, swc.Reserve(1))
+}
+
+func TestSlidingWindowCounter_Reserve_ResetAfterTwoWindows(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swc := core.NewSlidingWindowCounter(10, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swc.Reserve(10))
+	testutils.RequireEqual(t, testutils.NewTimeAt(1).Add(2*time.Minute), swc.ExpireAt())
+
+	clock.Advance(2 * time.Minute)
+	testutils.RequireNoError(t, swc.Reserve(10))
+	testutils.RequireEqual(t, 0.0, swc.Previous)
+}
+
+func TestSlidingWindowCounter_SortValue_IncreaseAfterReserve(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swc := core.NewSlidingWindowCounter(10, time.Minute).WitNowProvider(clock.Now)
+
+	sortValues := []string{swc.SortValue()}
+	for _, advance := range []time.Duration{0, time.Second, time.Minute, 2 * time.Minute} {
+		clock.Advance(advance)
+		testutils.RequireNoError(t, swc.Reserve(1))
+		sortValues = append(sortValues, swc.SortValue())
+	}
+
+	for i := 1; i < len(sortValues); i++ {
+		if sortValues[i-1] >= sortValues[i] {
+			t.Errorf("SlidingWindowCounter.SortValue() = %v is not less than %v", sortValues[i-1], sortValues[i])
+		}
+	}
+}
+
+func TestSlidingWindowCounter_CachedStore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	actualStore := core.NewInMemoryStore[*core.SlidingWindowCounter](10)
+	cachedStore := core.NewCachedStore[*core.SlidingWindowCounter](
+		ctx, testutils.NewNoOpLogger(), actualStore,
+		10, time.Hour,
+	)
+	rateLimiter := core.NewRateLimiter(
+		func() *core.SlidingWindowCounter {
+			return core.NewSlidingWindowCounter(2, 24*time.Hour)
+		},
+		cachedStore,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	//the local changes reach the actual store on close
+	testutils.RequireNoError(t, cachedStore.Close(ctx))
+
+	stored, err := actualStore.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	//a new window can start between the reservations
+	testutils.RequireEqual(t, 2.0, (*stored).Current+(*stored).Previous)
+}
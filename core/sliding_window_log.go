@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+type SlidingWindowEntry struct {
+	At     time.Time
+	Tokens float64
+}
+
+type SlidingWindowLog struct {
+	Entries     []SlidingWindowEntry
+	Limit       float64
+	Window      time.Duration
+	nowProvider func() time.Time //for test
+}
+
+var _ CheckedAlgorithm = &SlidingWindowLog{}
+var _ Configurable = &SlidingWindowLog{}
+var _ Cloner[*SlidingWindowLog] = &SlidingWindowLog{}
+
+func NewSlidingWindowLog(limit float64, window time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		Limit:  limit,
+		Window: window,
+	}
+}
+
+func (swl *SlidingWindowLog) WitNowProvider(fun func() time.Time) *SlidingWindowLog {
+	swl.nowProvider = fun
+	return swl
+}
+
+func (swl *SlidingWindowLog) now() time.Time {
+	if swl.nowProvider != nil {
+		return swl.nowProvider()
+	} else {
+		return time.Now()
+	}
+}
+
+func (swl *SlidingWindowLog) removeExpired(now time.Time) {
+	//entries are sorted by time, the expired ones are at the beginning
+	windowStart := now.Add(-swl.Window)
+
+	i := 0
+	for i < len(swl.Entries) && !swl.Entries[i].At.After(windowStart) {
+		i++
+	}
+
+	swl.Entries = swl.Entries[i:]
+}
+
+func (swl *SlidingWindowLog) usedTokens() float64 {
+	used := 0.0
+	for _, entry := range swl.Entries {
+		used += entry.Tokens
+	}
+
+	return used
+}
+
+func (swl *SlidingWindowLog) howMuchToWaitFor(now time.Time, tokens float64) time.Duration {
+	exceeding := swl.usedTokens() + tokens - swl.Limit
+
+	//the oldest entries are the first ones to leave the window
+	for _, entry := range swl.Entries {
+		exceeding -= entry.Tokens
+		if exceeding <= 0 {
+			return entry.At.Add(swl.Window).Sub(now)
+		}
+	}
+
+	return 0
+}
+
+func (swl *SlidingWindowLog) Reserve(tokens float64) error {
+	if tokens > swl.Limit {
+		return fmt.Errorf("can't reserve more than %f tokens:%w", swl.Limit, ErrOutOfBoundsRequest)
+	}
+
+	now := swl.now()
+	swl.removeExpired(now)
+
+	if swl.usedTokens()+tokens > swl.Limit {
+		return ErrTooManyRequests{RetryAfter: swl.howMuchToWaitFor(now, tokens)}
+	}
+
+	swl.Entries = append(swl.Entries, SlidingWindowEntry{At: now, Tokens: tokens})
+
+	return nil
+}
+
+func (swl *SlidingWindowLog) Check(tokens float64) error {
+	//reserve on a copy to leave the state untouched, the entries too
+	return swl.Clone().Reserve(tokens)
+}
+
+func (swl *SlidingWindowLog) Config() AlgorithmConfig {
+	return AlgorithmConfig{Burst: swl.Limit, Window: swl.Window}
+}
+
+func (swl *SlidingWindowLog) Configure(config AlgorithmConfig) error {
+	if config.Burst > 0 {
+		swl.Limit = config.Burst
+	}
+
+	if config.Window > 0 {
+		swl.Window = config.Window
+	}
+
+	return nil
+}
+
+func (swl *SlidingWindowLog) SortValue() string {
+	//the used tokens order the reservations made at the same instant
+	return sortValue(swl.ExpireAt(), swl.usedTokens())
+}
+
+func (swl *SlidingWindowLog) ExpireAt() time.Time {
+	if len(swl.Entries) == 0 {
+		return swl.now()
+	}
+
+	return swl.Entries[len(swl.Entries)-1].At.Add(swl.Window)
+}
+
+func (swl *SlidingWindowLog) Clone() *SlidingWindowLog {
+	clone := *swl
+	clone.Entries = slices.Clone(swl.Entries)
+	return &clone
+}
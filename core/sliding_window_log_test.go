@@ -0,0 +1,137 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestSlidingWindowLog_Reserve_RejectWhenWindowIsFull(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swl := core.NewSlidingWindowLog(3, time.Minute).WitNowProvider(clock.Now)
+
+	for i := 0; i < 3; i++ {
+		err := swl.Reserve(1)
+		testutils.RequireNoError(t, err)
+		clock.Advance(10 * time.Second)
+	}
+
+	tooManyReqErr := requireTooManyRequests(t, swl.Reserve(1))
+
+	//the first entry leaves the window 1 minute after it was added
+	testutils.RequireEqual(t, 30*time.Second, tooManyReqErr.RetryAfter)
+}
+
+func TestSlidingWindowLog_Reserve_RetryAfterWaitsForEnoughEntries(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swl := core.NewSlidingWindowLog(3, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swl.Reserve(1))
+	clock.Advance(10 * time.Second)
+	testutils.RequireNoError(t, swl.Reserve(1))
+	clock.Advance(10 * time.Second)
+	testutils.RequireNoError(t, swl.Reserve(1))
+
+	tooManyReqErr := requireTooManyRequests(t, swl.Reserve(2))
+
+	//two entries need to leave the window
+	testutils.RequireEqual(t, 50*time.Second, tooManyReqErr.RetryAfter)
+
+	clock.Advance(tooManyReqErr.RetryAfter)
+	testutils.RequireNoError(t, swl.Reserve(2))
+}
+
+func TestSlidingWindowLog_Reserve_AcceptAfterWindowIsPassed(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swl := core.NewSlidingWindowLog(1, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swl.Reserve(1))
+
+	clock.Advance(59 * time.Second)
+	if err := swl.Reserve(1); err == nil {
+		t.Fatalf("expected error inside the window")
+	}
+
+	clock.Advance(1 * time.Second)
+	testutils.RequireNoError(t, swl.Reserve(1))
+	testutils.RequireEqual(t, 1, len(swl.Entries))
+}
+
+func TestSlidingWindowLog_Reserve_OutOfBounds(t *testing.T) {
+	swl := core.NewSlidingWindowLog(1, time.Minute)
+
+	err := swl.Reserve(2)
+	if !errors.Is(err, core.ErrOutOfBoundsRequest) {
+		t.Fatalf("expected ErrOutOfBoundsRequest, got %v", err)
+	}
+}
+
+func TestSlidingWindowLog_SortValue_IncreaseAfterReserve(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swl := core.NewSlidingWindowLog(10, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swl.Reserve(1))
+	sortValue1 := swl.SortValue()
+	clock.Advance(time.Second)
+	testutils.RequireNoError(t, swl.Reserve(1))
+	sortValue2 := swl.SortValue()
+
+	if sortValue1 >= sortValue2 {
+		t.Errorf("SlidingWindowLog.SortValue() = %v is not less than %v", sortValue1, sortValue2)
+	}
+}
+
+func TestSlidingWindowLog_SortValue_IncreaseAfterReserveAtTheSameInstant(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swl := core.NewSlidingWindowLog(10, time.Minute).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, swl.Reserve(1))
+	sortValue1 := swl.SortValue()
+	testutils.RequireNoError(t, swl.Reserve(1))
+	sortValue2 := swl.SortValue()
+
+	if sortValue1 >= sortValue2 {
+		t.Errorf("SlidingWindowLog.SortValue() = %v is not less than %v", sortValue1, sortValue2)
+	}
+}
+
+func TestSlidingWindowLog_Check_WithoutChangingEntries(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	swl := core.NewSlidingWindowLog(10, time.Minute).WitNowProvider(clock.Now)
+	swl.Entries = make([]core.SlidingWindowEntry, 0, 4)
+
+	testutils.RequireNoError(t, swl.Reserve(1))
+	clone := *swl
+	clock.Advance(time.Second)
+	testutils.RequireNoError(t, swl.Check(1))
+
+	//the check doesn't write into the spare capacity shared with the copies
+	testutils.RequireNoError(t, clone.Reserve(2))
+	testutils.RequireNoError(t, swl.Check(1))
+	testutils.RequireEqual(t, 1, len(swl.Entries))
+	testutils.RequireEqual(t, 2.0, clone.Entries[1].Tokens)
+}
+
+func TestSlidingWindowLog_InMemoryStore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := core.NewInMemoryStore[*core.SlidingWindowLog](10)
+	rateLimiter := core.NewRateLimiter(
+		func() *core.SlidingWindowLog {
+			return core.NewSlidingWindowLog(2, time.Hour)
+		},
+		store,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	requireTooManyRequests(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	stored, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 2, len((*stored).Entries))
+}
@@ -4,20 +4,34 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"time"
 )
 
+type TokenHold struct {
+	ID       string
+	Tokens   float64
+	ExpireAt time.Time
+}
+
 type TokenBucket struct {
 	Tokens         float64
 	MaxTokens      float64
 	RefillRate     float64
 	LastRefillTime time.Time
+	Holds          []TokenHold
 	nowProvider    func() time.Time //for test
 }
 
-var _ Algorithm = &TokenBucket{}
+var _ CheckedAlgorithm = &TokenBucket{}
+var _ Refunder = &TokenBucket{}
+var _ Holder = &TokenBucket{}
+var _ Inspector = &TokenBucket{}
+var _ Configurable = &TokenBucket{}
+var _ Drainer = &TokenBucket{}
+var _ Cloner[*TokenBucket] = &TokenBucket{}
 
-var errOutOfBoundsRequest = errors.New("capacity requested is greater than the maximum allowed")
+var ErrOutOfBoundsRequest = errors.New("capacity requested is greater than the maximum allowed")
 
 func NewTokenBucket(maxTokens, refillRate float64) *TokenBucket {
 	return &TokenBucket{
@@ -49,6 +63,40 @@ func (tb *TokenBucket) refill() {
 	tokensToAdd := tb.RefillRate * duration.Seconds()
 	tb.Tokens = math.Min(tb.Tokens+tokensToAdd, tb.MaxTokens)
 	tb.LastRefillTime = now
+
+	tb.releaseExpiredHolds(now)
+}
+
+func (tb *TokenBucket) releaseExpiredHolds(now time.Time) {
+	//a new slice is built because the holds can be shared with a clone
+	var holds []TokenHold
+	for _, hold := range tb.Holds {
+		if hold.ExpireAt.After(now) {
+			holds = append(holds, hold)
+		} else {
+			tb.Tokens = math.Min(tb.Tokens+hold.Tokens, tb.MaxTokens)
+		}
+	}
+
+	tb.Holds = holds
+}
+
+func (tb *TokenBucket) removeHold(id string) (TokenHold, bool) {
+	var holds []TokenHold
+	var removed TokenHold
+	found := false
+
+	for _, hold := range tb.Holds {
+		if hold.ID == id {
+			removed = hold
+			found = true
+		} else {
+			holds = append(holds, hold)
+		}
+	}
+
+	tb.Holds = holds
+	return removed, found
 }
 
 func (tb *TokenBucket) howMuchToWaitFor(tokens float64) time.Duration {
@@ -59,12 +107,12 @@ func (tb *TokenBucket) howMuchToWaitFor(tokens float64) time.Duration {
 
 func (tb *TokenBucket) Reserve(tokens float64) error {
 	if tokens > tb.MaxTokens {
-		return fmt.Errorf("can't reserve more than %f tokens:%w", tb.MaxTokens, errOutOfBoundsRequest)
+		return fmt.Errorf("can't reserve more than %f tokens:%w", tb.MaxTokens, ErrOutOfBoundsRequest)
 	}
 
 	tb.refill()
 	if tokens > tb.Tokens {
-		return ErrTooManyRequests{tb.howMuchToWaitFor(tokens)}
+		return ErrTooManyRequests{RetryAfter: tb.howMuchToWaitFor(tokens)}
 	}
 
 	tb.Tokens -= tokens
@@ -72,11 +120,142 @@ func (tb *TokenBucket) Reserve(tokens float64) error {
 	return nil
 }
 
+func (tb *TokenBucket) Refund(tokens float64) error {
+	if tokens <= 0 {
+		return fmt.Errorf("can't refund %f tokens:%w", tokens, ErrOutOfBoundsRequest)
+	}
+
+	tb.refill()
+	tb.Tokens = math.Min(tb.Tokens+tokens, tb.MaxTokens)
+
+	return nil
+}
+
+func (tb *TokenBucket) Drain(retryAfter time.Duration) error {
+	if retryAfter <= 0 {
+		return fmt.Errorf("can't drain for %s:%w", retryAfter, ErrOutOfBoundsRequest)
+	}
+
+	tb.refill()
+	//the refill takes retryAfter to bring the tokens back to zero
+	tb.Tokens = math.Min(tb.Tokens, -tb.RefillRate*retryAfter.Seconds())
+
+	return nil
+}
+
+func (tb *TokenBucket) Hold(id string, tokens float64, timeout time.Duration) error {
+	err := tb.Reserve(tokens)
+	if err != nil {
+		return err
+	}
+
+	tb.Holds = append(tb.Holds, TokenHold{ID: id, Tokens: tokens, ExpireAt: tb.now().Add(timeout)})
+
+	return nil
+}
+
+func (tb *TokenBucket) Commit(id string) error {
+	err := tb.checkHold(id)
+	if err != nil {
+		return err
+	}
+
+	tb.refill()
+	tb.removeHold(id)
+
+	return nil
+}
+
+func (tb *TokenBucket) Cancel(id string) error {
+	err := tb.checkHold(id)
+	if err != nil {
+		return err
+	}
+
+	tb.refill()
+
+	hold, _ := tb.removeHold(id)
+	tb.Tokens = math.Min(tb.Tokens+hold.Tokens, tb.MaxTokens)
+
+	return nil
+}
+
+// checkHold runs before refill, which releases the expired holds
+func (tb *TokenBucket) checkHold(id string) error {
+	for _, hold := range tb.Holds {
+		if hold.ID != id {
+			continue
+		}
+
+		if !hold.ExpireAt.After(tb.now()) {
+			return fmt.Errorf("can't finish hold %s: %w", id, ErrHoldExpired)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("can't finish hold %s: %w", id, ErrHoldNotFound)
+}
+
+func (tb *TokenBucket) Clone() *TokenBucket {
+	clone := *tb
+	clone.Holds = slices.Clone(tb.Holds)
+	return &clone
+}
+
+func (tb *TokenBucket) Check(tokens float64) error {
+	//reserve on a copy to leave the state untouched
+	clone := *tb
+	return clone.Reserve(tokens)
+}
+
+func (tb *TokenBucket) Inspect(tokens float64) Status {
+	//refill a copy to leave LastRefillTime untouched
+	clone := *tb
+	clone.refill()
+
+	return Status{
+		Limit:      clone.MaxTokens,
+		Remaining:  math.Max(clone.Tokens, 0), //a drain can leave the bucket in debt
+		ResetAt:    clone.LastRefillTime.Add(clone.howMuchToWaitFor(clone.MaxTokens)),
+		RetryAfter: clone.howMuchToWaitFor(tokens),
+	}
+}
+
+func (tb *TokenBucket) Config() AlgorithmConfig {
+	return AlgorithmConfig{Burst: tb.MaxTokens, Rate: tb.RefillRate}
+}
+
+func (tb *TokenBucket) Configure(config AlgorithmConfig) error {
+	tb.refill()
+
+	if config.Burst > 0 && config.Burst != tb.MaxTokens {
+		spent := tb.MaxTokens - tb.Tokens
+		tb.MaxTokens = config.Burst
+		tb.Tokens = math.Max(tb.MaxTokens-spent, 0)
+	}
+
+	if config.Rate > 0 {
+		tb.RefillRate = config.Rate
+	}
+
+	return nil
+}
+
 func (tb *TokenBucket) SortValue() string {
 	return fmt.Sprintf("%v", tb.ExpireAt())
 }
 
 func (tb *TokenBucket) ExpireAt() time.Time {
 	durationSeconds := tb.howMuchToWaitFor(tb.MaxTokens)
-	return tb.now().Add(durationSeconds)
+	expireAt := tb.now().Add(durationSeconds)
+
+	//the holds are released on refill, the bucket must outlive them
+	for _, hold := range tb.Holds {
+		if hold.ExpireAt.After(expireAt) {
+			expireAt = hold.ExpireAt
+		}
+	}
+
+	return expireAt
 }
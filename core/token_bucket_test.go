@@ -1,7 +1,9 @@
 package core_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/hizumisen/go-rate-limiter/core"
 	"github.com/hizumisen/go-rate-limiter/internal/testutils"
@@ -36,3 +38,126 @@ func TestTokenBucket_SortValue_CreaseMoreIfMoreIsReserved(t *testing.T) {
 		t.Errorf("TokenBucket.SortValue() = %v is less or equal than %v", sortValue1, sortValue2)
 	}
 }
+
+func TestTokenBucket_Refund_CappedAtMaxTokens(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Reserve(5))
+	testutils.RequireNoError(t, token.Refund(3))
+	testutils.RequireEqual(t, 8.0, token.Tokens)
+
+	testutils.RequireNoError(t, token.Refund(5))
+	testutils.RequireEqual(t, 10.0, token.Tokens)
+}
+
+func TestTokenBucket_Refund_RejectNonPositiveTokens(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Reserve(5))
+	for _, tokens := range []float64{0, -3} {
+		err := token.Refund(tokens)
+		if !errors.Is(err, core.ErrOutOfBoundsRequest) {
+			t.Fatalf("expected ErrOutOfBoundsRequest, got %v", err)
+		}
+	}
+
+	testutils.RequireEqual(t, 5.0, token.Tokens)
+}
+
+func TestTokenBucket_Hold_ReleasedOnExpiry(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 0.001).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Hold("hold1", 6, time.Minute))
+	testutils.RequireNoError(t, token.Hold("hold2", 4, 5*time.Hour))
+	testutils.RequireEqual(t, clock.Now().Add(5*time.Hour), token.ExpireAt())
+	requireTooManyRequests(t, token.Check(1))
+
+	clock.Advance(time.Minute)
+	testutils.RequireNoError(t, token.Check(6))
+	requireTooManyRequests(t, token.Check(7))
+
+	//a committed hold keeps its tokens
+	testutils.RequireNoError(t, token.Commit("hold2"))
+	testutils.RequireEqual(t, 0, len(token.Holds))
+	requireTooManyRequests(t, token.Check(7))
+}
+
+func TestTokenBucket_Cancel_GiveBackHeldTokens(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 0.001).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Hold("hold1", 6, time.Minute))
+	testutils.RequireNoError(t, token.Cancel("hold1"))
+	testutils.RequireEqual(t, 0, len(token.Holds))
+	testutils.RequireNoError(t, token.Check(10))
+
+	//cancelling twice doesn't give the tokens back again
+	testutils.RequireNoError(t, token.Reserve(5))
+	err := token.Cancel("hold1")
+	if !errors.Is(err, core.ErrHoldNotFound) {
+		t.Fatalf("expected ErrHoldNotFound, got %v", err)
+	}
+	requireTooManyRequests(t, token.Check(6))
+}
+
+func TestTokenBucket_Commit_RejectExpiredHold(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 0.001).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Hold("hold1", 6, time.Minute))
+	clock.Advance(time.Minute)
+
+	err := token.Commit("hold1")
+	if !errors.Is(err, core.ErrHoldExpired) {
+		t.Fatalf("expected ErrHoldExpired, got %v", err)
+	}
+
+	//the expired hold gave its tokens back
+	testutils.RequireNoError(t, token.Check(10))
+}
+
+func TestTokenBucket_Inspect_WithoutChangingState(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Reserve(8))
+	clock.Advance(2 * time.Second)
+
+	status := token.Inspect(6)
+	testutils.RequireEqual(t, 10.0, status.Limit)
+	testutils.RequireEqual(t, 4.0, status.Remaining)
+	testutils.RequireEqual(t, clock.Now().Add(6*time.Second), status.ResetAt)
+	testutils.RequireEqual(t, 2*time.Second, status.RetryAfter)
+
+	testutils.RequireEqual(t, 2.0, token.Tokens)
+	testutils.RequireEqual(t, testutils.NewTimeAt(1), token.LastRefillTime)
+}
+
+func TestTokenBucket_Inspect_NoNegativeRemainingAfterDrain(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Drain(5*time.Second))
+
+	status := token.Inspect(1)
+	testutils.RequireEqual(t, 0.0, status.Remaining)
+	testutils.RequireEqual(t, 6*time.Second, status.RetryAfter)
+}
+
+func TestTokenBucket_Configure_KeepSpentTokens(t *testing.T) {
+	clock := testutils.NewClock(testutils.NewTimeAt(1))
+	token := core.NewTokenBucket(10, 1).WitNowProvider(clock.Now)
+
+	testutils.RequireNoError(t, token.Reserve(8))
+	clock.Advance(2 * time.Second)
+
+	testutils.RequireNoError(t, token.Configure(core.AlgorithmConfig{Burst: 100, Rate: 10}))
+	testutils.RequireEqual(t, core.AlgorithmConfig{Burst: 100, Rate: 10}, token.Config())
+	testutils.RequireEqual(t, 94.0, token.Tokens)
+
+	testutils.RequireNoError(t, token.Configure(core.AlgorithmConfig{Burst: 5}))
+	testutils.RequireEqual(t, 0.0, token.Tokens)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hizumisen/go-rate-limiter/core"
 
@@ -14,8 +17,10 @@ import (
 )
 
 type DynamoDbStore[T core.Algorithm] struct {
-	client    *dynamodb.Client
-	tableName *string
+	client            *dynamodb.Client
+	tableName         *string
+	maxUpdateAttempts int
+	batchParallelism  int
 }
 
 func NewDynamoDbStore[T core.Algorithm](
@@ -23,23 +28,45 @@ func NewDynamoDbStore[T core.Algorithm](
 	tableName string,
 ) *DynamoDbStore[T] {
 	return &DynamoDbStore[T]{
-		client:    client,
-		tableName: &tableName,
+		client:            client,
+		tableName:         &tableName,
+		maxUpdateAttempts: 10,
+		batchParallelism:  10,
 	}
 }
 
+func (store *DynamoDbStore[T]) WithMaxUpdateAttempts(maxUpdateAttempts int) *DynamoDbStore[T] {
+	store.maxUpdateAttempts = maxUpdateAttempts
+	return store
+}
+
+// WithBatchParallelism sets how many conditional updates StoreMany makes at a time
+func (store *DynamoDbStore[T]) WithBatchParallelism(batchParallelism int) *DynamoDbStore[T] {
+	store.batchParallelism = batchParallelism
+	return store
+}
+
 var _ core.AlgorithmStorer[*core.TokenBucket] = &DynamoDbStore[*core.TokenBucket]{}
+var _ core.AtomicStorer[*core.TokenBucket] = &DynamoDbStore[*core.TokenBucket]{}
+var _ core.BatchStorer[*core.TokenBucket] = &DynamoDbStore[*core.TokenBucket]{}
+
+var ErrTooManyConflicts = errors.New("too many concurrent updates on the same key")
+var ErrUnprocessedKeys = errors.New("keys left unprocessed by dynamodb")
 
 const (
 	keyKey      = "rateKey"
 	algKey      = "alg"
 	sortKey     = "sort"
 	expireAtKey = "expireAt"
+	versionKey  = "version"
+
+	maxBatchGetKeys = 100 //the limit of a BatchGetItem request
 )
 
 type dynamodbItem[T any] struct {
-	Key string `dynamodbav:"rateKey"`
-	Alg T      `dynamodbav:"alg"`
+	Key     string `dynamodbav:"rateKey"`
+	Alg     T      `dynamodbav:"alg"`
+	Version int64  `dynamodbav:"version"`
 }
 
 type TableConfiguration struct {
@@ -60,15 +87,36 @@ func GetTableConfiguration() TableConfiguration {
 	}
 }
 
-func (store *DynamoDbStore[T]) decodeAlg(data map[string]types.AttributeValue) (T, error) {
+func (store *DynamoDbStore[T]) decodeItem(data map[string]types.AttributeValue) (dynamodbItem[T], error) {
 	var dbItem dynamodbItem[T]
 
 	err := attributevalue.UnmarshalMap(data, &dbItem)
 	if err != nil {
-		return dbItem.Alg, fmt.Errorf("can't unmarshall dynamodb item to go object: %w", err)
+		return dbItem, fmt.Errorf("can't unmarshall dynamodb item to go object: %w", err)
 	}
 
-	return dbItem.Alg, nil
+	return dbItem, nil
+}
+
+func (store *DynamoDbStore[T]) decodeAlg(data map[string]types.AttributeValue) (T, error) {
+	dbItem, err := store.decodeItem(data)
+	return dbItem.Alg, err
+}
+
+func (store *DynamoDbStore[T]) algAttributes(alg T) (map[string]types.AttributeValue, error) {
+	dynamoDbAlg, err := attributevalue.Marshal(alg)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshall `alg` into dynamodb item: %w", err)
+	}
+
+	//dynamodb ttl only works with epoch seconds stored as a number
+	expireAt := &types.AttributeValueMemberN{Value: strconv.FormatInt(alg.ExpireAt().Unix(), 10)}
+
+	return map[string]types.AttributeValue{
+		":alg":      dynamoDbAlg,
+		":sort":     &types.AttributeValueMemberS{Value: alg.SortValue()},
+		":expireAt": expireAt,
+	}, nil
 }
 
 func (store *DynamoDbStore[T]) Store(
@@ -78,15 +126,12 @@ func (store *DynamoDbStore[T]) Store(
 ) (T, error) {
 	var defaultVal T
 
-	dynamoDbAlg, err := attributevalue.Marshal(alg)
+	values, err := store.algAttributes(alg)
 	if err != nil {
-		return defaultVal, fmt.Errorf("can't marshall `alg` into dynamodb item: %w", err)
+		return defaultVal, err
 	}
 
-	expireAt, err := attributevalue.Marshal(alg.ExpireAt())
-	if err != nil {
-		return defaultVal, fmt.Errorf("can't marshall `expireAt` into dynamodb item: %w", err)
-	}
+	values[":one"] = &types.AttributeValueMemberN{Value: "1"}
 
 	request := dynamodb.UpdateItemInput{
 		TableName: store.tableName,
@@ -94,12 +139,12 @@ func (store *DynamoDbStore[T]) Store(
 			keyKey: &types.AttributeValueMemberS{Value: key},
 		},
 		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s) or  %s < :sort", sortKey, sortKey)),
-		UpdateExpression:    aws.String(fmt.Sprintf("SET %s = :alg, %s = :sort, %s = :expireAt", algKey, sortKey, expireAtKey)),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":alg":      dynamoDbAlg,
-			":sort":     &types.AttributeValueMemberS{Value: alg.SortValue()},
-			":expireAt": expireAt,
-		},
+		UpdateExpression: aws.String(fmt.Sprintf(
+			"SET %s = :alg, %s = :sort, %s = :expireAt ADD #version :one",
+			algKey, sortKey, expireAtKey,
+		)),
+		ExpressionAttributeNames:            map[string]string{"#version": versionKey},
+		ExpressionAttributeValues:           values,
 		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 		ReturnValues:                        types.ReturnValueAllNew,
 	}
@@ -128,7 +173,7 @@ func (store *DynamoDbStore[T]) Store(
 	return alg, nil
 }
 
-func (store DynamoDbStore[T]) Load(ctx context.Context, key string) (*T, error) {
+func (store DynamoDbStore[T]) loadItem(ctx context.Context, key string) (*dynamodbItem[T], error) {
 	input := &dynamodb.GetItemInput{
 		TableName: store.tableName,
 		Key: map[string]types.AttributeValue{
@@ -146,10 +191,199 @@ func (store DynamoDbStore[T]) Load(ctx context.Context, key string) (*T, error)
 		return nil, nil
 	}
 
-	alg, err := store.decodeAlg(result.Item)
+	dbItem, err := store.decodeItem(result.Item)
 	if err != nil {
 		return nil, err
 	}
 
-	return &alg, nil
+	return &dbItem, nil
+}
+
+func (store DynamoDbStore[T]) Load(ctx context.Context, key string) (*T, error) {
+	dbItem, err := store.loadItem(ctx, key)
+	if err != nil || dbItem == nil {
+		return nil, err
+	}
+
+	return &dbItem.Alg, nil
+}
+
+func (store *DynamoDbStore[T]) Update(
+	ctx context.Context,
+	key string,
+	fun func(alg *T) (T, error),
+) (T, error) {
+	var defaultVal T
+
+	for attempt := 0; attempt < store.maxUpdateAttempts; attempt++ {
+		dbItem, err := store.loadItem(ctx, key)
+		if err != nil {
+			return defaultVal, err
+		}
+
+		var current *T
+		var version int64
+		if dbItem != nil {
+			current = &dbItem.Alg
+			version = dbItem.Version
+		}
+
+		alg, err := fun(current)
+		if err != nil {
+			return alg, err
+		}
+
+		err = store.storeVersion(ctx, key, alg, version)
+		if err != nil {
+			var errCheck *types.ConditionalCheckFailedException
+			if errors.As(err, &errCheck) {
+				continue
+			}
+
+			return defaultVal, err
+		}
+
+		return alg, nil
+	}
+
+	return defaultVal, ErrTooManyConflicts
+}
+
+func (store *DynamoDbStore[T]) storeVersion(
+	ctx context.Context,
+	key string,
+	alg T,
+	version int64,
+) error {
+	values, err := store.algAttributes(alg)
+	if err != nil {
+		return err
+	}
+
+	values[":nextVersion"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)}
+
+	//items written before versioning, or missing ones, have no version
+	condition := "attribute_not_exists(#version)"
+	if version > 0 {
+		condition = "#version = :version"
+		values[":version"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)}
+	}
+
+	request := dynamodb.UpdateItemInput{
+		TableName: store.tableName,
+		Key: map[string]types.AttributeValue{
+			keyKey: &types.AttributeValueMemberS{Value: key},
+		},
+		ConditionExpression: aws.String(condition),
+		UpdateExpression: aws.String(fmt.Sprintf(
+			"SET %s = :alg, %s = :sort, %s = :expireAt, #version = :nextVersion",
+			algKey, sortKey, expireAtKey,
+		)),
+		ExpressionAttributeNames:  map[string]string{"#version": versionKey},
+		ExpressionAttributeValues: values,
+	}
+
+	_, err = store.client.UpdateItem(ctx, &request)
+	if err != nil {
+		return fmt.Errorf("can't update item into dynamodb: %w", err)
+	}
+
+	return nil
+}
+
+// StoreMany makes a conditional update for each key, as Store does, since
+// BatchWriteItem doesn't support conditions
+func (store *DynamoDbStore[T]) StoreMany(ctx context.Context, algs map[string]T) (map[string]T, error) {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, max(store.batchParallelism, 1))
+
+	stored := make(map[string]T, len(algs))
+	var errs []error
+
+	for key, alg := range algs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(key string, alg T) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			alg, err := store.Store(ctx, key, alg)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				errs = append(errs, core.ErrKeyFailed{Key: key, Err: fmt.Errorf("can't store: %w", err)})
+				return
+			}
+
+			stored[key] = alg
+		}(key, alg)
+	}
+
+	wg.Wait()
+
+	return stored, errors.Join(errs...)
+}
+
+func (store *DynamoDbStore[T]) LoadMany(ctx context.Context, keys []string) (map[string]T, error) {
+	algs := make(map[string]T, len(keys))
+
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		err := store.batchGet(ctx, keys[start:min(start+maxBatchGetKeys, len(keys))], algs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return algs, nil
+}
+
+func (store *DynamoDbStore[T]) batchGet(ctx context.Context, keys []string, algs map[string]T) error {
+	requestKeys := make([]map[string]types.AttributeValue, len(keys))
+	for i, key := range keys {
+		requestKeys[i] = map[string]types.AttributeValue{
+			keyKey: &types.AttributeValueMemberS{Value: key},
+		}
+	}
+
+	requestItems := map[string]types.KeysAndAttributes{
+		*store.tableName: {
+			Keys:           requestKeys,
+			ConsistentRead: aws.Bool(true),
+		},
+	}
+
+	//dynamodb can return a part of the keys, the rest must be requested again
+	for attempt := 0; attempt < store.maxUpdateAttempts; attempt++ {
+		result, err := store.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+		if err != nil {
+			return fmt.Errorf("can't batch get items from dynamodb: %w", err)
+		}
+
+		for _, data := range result.Responses[*store.tableName] {
+			dbItem, err := store.decodeItem(data)
+			if err != nil {
+				return err
+			}
+
+			algs[dbItem.Key] = dbItem.Alg
+		}
+
+		if len(result.UnprocessedKeys) == 0 {
+			return nil
+		}
+
+		requestItems = result.UnprocessedKeys
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("can't batch get items from dynamodb: %w", ctx.Err())
+		case <-time.After(time.Duration(attempt+1) * 50 * time.Millisecond):
+		}
+	}
+
+	return ErrUnprocessedKeys
 }
@@ -3,11 +3,18 @@ package dynamodb_test
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hizumisen/go-rate-limiter/core"
 	"github.com/hizumisen/go-rate-limiter/dynamodb"
 	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 type storedItem time.Time
@@ -35,11 +42,24 @@ func (s storedItem) String() string {
 func buildStore(ctx context.Context, t *testing.T) *dynamodb.DynamoDbStore[storedItem] {
 	t.Helper()
 
+	return buildAlgorithmStore[storedItem](ctx, t)
+}
+
+func buildAlgorithmStore[T core.Algorithm](ctx context.Context, t *testing.T) *dynamodb.DynamoDbStore[T] {
+	t.Helper()
+
+	dyanamodbClient, tableName := buildTable(ctx, t)
+	return dynamodb.NewDynamoDbStore[T](dyanamodbClient, tableName)
+}
+
+func buildTable(ctx context.Context, t *testing.T) (*awsdynamodb.Client, string) {
+	t.Helper()
+
 	dyanamodbClient, err := dynamodb.NewDynamodbClient(ctx)
 	testutils.RequireNoError(t, err)
 	tableName := fmt.Sprintf("rate-limit-%d", time.Now().UnixNano())
 	dynamodb.CreateTableIfMissing(ctx, dyanamodbClient, tableName, dynamodb.GetTableConfiguration())
-	return dynamodb.NewDynamoDbStore[storedItem](dyanamodbClient, tableName)
+	return dyanamodbClient, tableName
 }
 
 func TestDynamoDbStore_Store_NewAlg(t *testing.T) {
@@ -83,3 +103,191 @@ func TestDynamoDbStore_Store_NotOverrideAlgIfSortIsLesserAndReturnStored(t *test
 	testutils.RequireNoError(t, err)
 	testutils.RequireEqual(t, newStoredItemAtHour(2), got)
 }
+
+func TestDynamoDbStore_Store_ExpireAtInEpochSeconds(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dyanamodbClient, tableName := buildTable(ctx, t)
+	store := dynamodb.NewDynamoDbStore[storedItem](dyanamodbClient, tableName)
+
+	_, err := store.Store(ctx, "key1", newStoredItemAtHour(1))
+	testutils.RequireNoError(t, err)
+
+	result, err := dyanamodbClient.GetItem(ctx, &awsdynamodb.GetItemInput{
+		TableName: &tableName,
+		Key: map[string]types.AttributeValue{
+			"rateKey": &types.AttributeValueMemberS{Value: "key1"},
+		},
+	})
+	testutils.RequireNoError(t, err)
+
+	//the ttl of dynamodb ignores the attributes that aren't numbers
+	expireAt, ok := result.Item["expireAt"].(*types.AttributeValueMemberN)
+	if !ok {
+		t.Fatalf("expected expireAt to be a number, got %T", result.Item["expireAt"])
+	}
+
+	testutils.RequireEqual(t, strconv.FormatInt(newStoredItemAtHour(1).ExpireAt().Unix(), 10), expireAt.Value)
+}
+
+func TestDynamoDbStore_Update_NewAlg(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := buildStore(ctx, t)
+
+	got, err := store.Update(ctx, "key1", func(alg *storedItem) (storedItem, error) {
+		if alg != nil {
+			t.Errorf("expected missing alg, got %v", *alg)
+		}
+
+		return newStoredItemAtHour(1), nil
+	})
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), got)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), *loaded)
+}
+
+func TestDynamoDbStore_Update_OverrideEvenIfSortIsLesser(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := buildStore(ctx, t)
+
+	_, err := store.Store(ctx, "key1", newStoredItemAtHour(2))
+	testutils.RequireNoError(t, err)
+
+	got, err := store.Update(ctx, "key1", func(alg *storedItem) (storedItem, error) {
+		testutils.RequireEqual(t, newStoredItemAtHour(2), *alg)
+		return newStoredItemAtHour(1), nil
+	})
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), got)
+}
+
+func TestDynamoDbStore_Update_NoLostUpdates(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dyanamodbClient, err := dynamodb.NewDynamodbClient(ctx)
+	testutils.RequireNoError(t, err)
+	tableName := fmt.Sprintf("rate-limit-%d", time.Now().UnixNano())
+	dynamodb.CreateTableIfMissing(ctx, dyanamodbClient, tableName, dynamodb.GetTableConfiguration())
+	store := dynamodb.NewDynamoDbStore[*core.TokenBucket](dyanamodbClient, tableName).WithMaxUpdateAttempts(100)
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(10, 0.001)
+		},
+		store,
+	)
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rateLimiter.Reserve(ctx, "key1", 1) == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	testutils.RequireEqual(t, int32(10), accepted.Load())
+}
+
+func TestDynamoDbStore_StoreMany_OverrideAlgIfSortIsGreater(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := buildStore(ctx, t)
+
+	_, err := store.Store(ctx, "key1", newStoredItemAtHour(2))
+	testutils.RequireNoError(t, err)
+
+	got, err := store.StoreMany(ctx, map[string]storedItem{
+		"key1": newStoredItemAtHour(1),
+		"key2": newStoredItemAtHour(1),
+	})
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 2, len(got))
+	testutils.RequireEqual(t, newStoredItemAtHour(2), got["key1"]) //stored version
+	testutils.RequireEqual(t, newStoredItemAtHour(1), got["key2"])
+}
+
+func TestDynamoDbStore_LoadMany_SkipMissingKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := buildStore(ctx, t)
+
+	keys := []string{"missing"}
+	algs := make(map[string]storedItem)
+	for i := 0; i < 150; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		algs[key] = newStoredItemAtHour(i % 24)
+	}
+
+	_, err := store.StoreMany(ctx, algs)
+	testutils.RequireNoError(t, err)
+
+	got, err := store.LoadMany(ctx, keys)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, len(algs), len(got))
+	for key, alg := range algs {
+		testutils.RequireEqual(t, alg, got[key])
+	}
+}
+
+func TestDynamoDbStore_SlidingWindowLog_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := buildAlgorithmStore[*core.SlidingWindowLog](ctx, t)
+	rateLimiter := core.NewRateLimiter(
+		func() *core.SlidingWindowLog {
+			return core.NewSlidingWindowLog(2, time.Hour)
+		},
+		store,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireErrorAs[core.ErrTooManyRequests](t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	stored, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 2, len((*stored).Entries))
+	testutils.RequireEqual(t, 2.0, (*stored).Limit)
+	testutils.RequireEqual(t, time.Hour, (*stored).Window)
+}
+
+func TestDynamoDbStore_SlidingWindowCounter_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := buildAlgorithmStore[*core.SlidingWindowCounter](ctx, t)
+	rateLimiter := core.NewRateLimiter(
+		func() *core.SlidingWindowCounter {
+			return core.NewSlidingWindowCounter(2, 24*time.Hour)
+		},
+		store,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireErrorAs[core.ErrTooManyRequests](t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	stored, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	//a new window can start between the reservations
+	testutils.RequireEqual(t, 2.0, (*stored).Current+(*stored).Previous)
+	testutils.RequireEqual(t, 24*time.Hour, (*stored).Window)
+}
@@ -0,0 +1,74 @@
+package grpclimit
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor retries the calls rejected with a RetryInfo detail
+// after the delay the server asked for, up to maxRetries times and as long as
+// the ctx deadline allows it
+func UnaryClientInterceptor(maxRetries int) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		for attempt := 0; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= maxRetries {
+				return err
+			}
+
+			retryDelay, ok := retryDelay(err)
+			if !ok {
+				return err
+			}
+
+			deadline, ok := ctx.Deadline()
+			if ok && time.Until(deadline) < retryDelay {
+				return err
+			}
+
+			if sleep(ctx, retryDelay) != nil {
+				return err
+			}
+		}
+	}
+}
+
+func retryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+
+	for _, detail := range st.Details() {
+		retryInfo, ok := detail.(*errdetails.RetryInfo)
+		if ok && retryInfo.GetRetryDelay() != nil {
+			return retryInfo.GetRetryDelay().AsDuration(), true
+		}
+	}
+
+	return 0, false
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
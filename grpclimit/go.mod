@@ -0,0 +1,19 @@
+module github.com/hizumisen/go-rate-limiter/grpclimit
+
+go 1.21.6
+
+replace github.com/hizumisen/go-rate-limiter/core => ../core
+
+require (
+	github.com/hizumisen/go-rate-limiter/core v0.0.0-00010101000000-000000000000
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240123012728-ef4313101c80
+	google.golang.org/grpc v1.62.1
+	google.golang.org/protobuf v1.32.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
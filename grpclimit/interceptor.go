@@ -0,0 +1,117 @@
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+)
+
+type Limiter interface {
+	Reserve(ctx context.Context, key string, tokens float64) error
+}
+
+type Interceptor struct {
+	limiter  Limiter
+	keyFunc  KeyFunc
+	failOpen bool
+	logger   *slog.Logger
+}
+
+func NewInterceptor(
+	logger *slog.Logger,
+	limiter Limiter,
+	keyFunc KeyFunc,
+) Interceptor {
+	return Interceptor{
+		limiter: limiter,
+		keyFunc: keyFunc,
+		logger:  logger,
+	}
+}
+
+// WithFailOpen lets the calls through when the limiter fails, by default
+// they are rejected with codes.Unavailable
+func (i Interceptor) WithFailOpen(failOpen bool) Interceptor {
+	i.failOpen = failOpen
+	return i
+}
+
+func (i Interceptor) reserve(ctx context.Context, fullMethod string) error {
+	key, err := i.keyFunc(ctx, fullMethod)
+	if err != nil {
+		i.logger.Debug("can't extract the rate limit key", "error", err)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = i.limiter.Reserve(ctx, key, 1)
+
+	var tooManyReqErr core.ErrTooManyRequests
+	if errors.As(err, &tooManyReqErr) {
+		return resourceExhausted(tooManyReqErr)
+	}
+
+	//the call costs more than the limit, retrying wouldn't help
+	if errors.Is(err, core.ErrOutOfBoundsRequest) {
+		i.logger.Debug("rate limit cost out of bounds", "key", key, "error", err)
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	if err != nil {
+		i.logger.Warn("can't reserve rate limit tokens", "key", key, "error", err, "failOpen", i.failOpen)
+		if !i.failOpen {
+			return status.Error(codes.Unavailable, "rate limiter unavailable")
+		}
+	}
+
+	return nil
+}
+
+func resourceExhausted(tooManyReqErr core.ErrTooManyRequests) error {
+	st := status.New(codes.ResourceExhausted, tooManyReqErr.Error())
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(tooManyReqErr.RetryAfter),
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+func (i Interceptor) UnaryServerInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	err := i.reserve(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor reserves a token when the stream is opened
+func (i Interceptor) StreamServerInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	err := i.reserve(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
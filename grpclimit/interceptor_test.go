@@ -0,0 +1,167 @@
+package grpclimit_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/grpclimit"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+type failingLimiter struct{}
+
+func (failingLimiter) Reserve(ctx context.Context, key string, tokens float64) error {
+	return errors.New("store unavailable")
+}
+
+func newRateLimiter(maxTokens, refillRate float64) core.RateLimiter[*core.TokenBucket] {
+	return core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(maxTokens, refillRate)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+}
+
+func buildClient(
+	t *testing.T,
+	interceptor grpclimit.Interceptor,
+	clientOpts ...grpc.DialOption,
+) healthpb.HealthClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.UnaryServerInterceptor),
+		grpc.StreamInterceptor(interceptor.StreamServerInterceptor),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, clientOpts...)
+
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	testutils.RequireNoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func withUser(user string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-user", user)
+}
+
+func TestInterceptor_UnaryServerInterceptor_ResourceExhausted(t *testing.T) {
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), newRateLimiter(1, 0.1), grpclimit.Metadata("x-user"))
+	client := buildClient(t, interceptor)
+
+	_, err := client.Check(withUser("user1"), &healthpb.HealthCheckRequest{})
+	testutils.RequireNoError(t, err)
+
+	_, err = client.Check(withUser("user1"), &healthpb.HealthCheckRequest{})
+	st := status.Convert(err)
+	testutils.RequireEqual(t, codes.ResourceExhausted, st.Code())
+	testutils.RequireEqual(t, 1, len(st.Details()))
+	retryInfo := st.Details()[0].(*errdetails.RetryInfo)
+	retryDelay := retryInfo.GetRetryDelay().AsDuration()
+	if retryDelay < 9*time.Second || retryDelay > 10*time.Second {
+		t.Errorf("expected a retry delay of about 10s, got %s", retryDelay)
+	}
+
+	_, err = client.Check(withUser("user2"), &healthpb.HealthCheckRequest{})
+	testutils.RequireNoError(t, err)
+}
+
+func TestInterceptor_UnaryServerInterceptor_MissingKey(t *testing.T) {
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), newRateLimiter(1, 0.1), grpclimit.Metadata("x-user"))
+	client := buildClient(t, interceptor)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	testutils.RequireEqual(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestInterceptor_UnaryServerInterceptor_FailOpen(t *testing.T) {
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), failingLimiter{}, grpclimit.FullMethod)
+
+	client := buildClient(t, interceptor)
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	testutils.RequireEqual(t, codes.Unavailable, status.Code(err))
+
+	client = buildClient(t, interceptor.WithFailOpen(true))
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	testutils.RequireNoError(t, err)
+}
+
+func TestInterceptor_UnaryServerInterceptor_OutOfBounds(t *testing.T) {
+	//the calls cost 1 token, over the limit, even when failing open
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), newRateLimiter(0.5, 0.1), grpclimit.FullMethod).
+		WithFailOpen(true)
+	client := buildClient(t, interceptor)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	testutils.RequireEqual(t, codes.ResourceExhausted, status.Code(err))
+	testutils.RequireEqual(t, 0, len(status.Convert(err).Details()))
+}
+
+func TestInterceptor_StreamServerInterceptor_ResourceExhausted(t *testing.T) {
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), newRateLimiter(1, 0.1), grpclimit.PeerAddress)
+	client := buildClient(t, interceptor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	testutils.RequireNoError(t, err)
+	_, err = stream.Recv()
+	testutils.RequireNoError(t, err)
+
+	stream, err = client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	testutils.RequireNoError(t, err)
+	_, err = stream.Recv()
+	testutils.RequireEqual(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestUnaryClientInterceptor_RetryAfterDelay(t *testing.T) {
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), newRateLimiter(1, 20), grpclimit.FullMethod)
+	client := buildClient(t, interceptor, grpc.WithUnaryInterceptor(grpclimit.UnaryClientInterceptor(1)))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		testutils.RequireNoError(t, err)
+	}
+}
+
+func TestUnaryClientInterceptor_NotWaitingPastDeadline(t *testing.T) {
+	interceptor := grpclimit.NewInterceptor(testutils.NewNoOpLogger(), newRateLimiter(1, 0.1), grpclimit.FullMethod)
+	client := buildClient(t, interceptor, grpc.WithUnaryInterceptor(grpclimit.UnaryClientInterceptor(1)))
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	testutils.RequireNoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	testutils.RequireEqual(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,57 @@
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var ErrMissingKey = errors.New("missing rate limit key")
+
+type KeyFunc func(ctx context.Context, fullMethod string) (string, error)
+
+func Metadata(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 || values[0] == "" {
+			return "", fmt.Errorf("can't find metadata %s: %w", name, ErrMissingKey)
+		}
+
+		return values[0], nil
+	}
+}
+
+func PeerAddress(ctx context.Context, fullMethod string) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", fmt.Errorf("can't find the peer: %w", ErrMissingKey)
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		//not an ip address, as for unix sockets
+		return p.Addr.String(), nil
+	}
+
+	return host, nil
+}
+
+func FullMethod(ctx context.Context, fullMethod string) (string, error) {
+	return fullMethod, nil
+}
+
+// MethodAnd limits every caller on every method separately
+func MethodAnd(keyFunc KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		key, err := keyFunc(ctx, fullMethod)
+		if err != nil {
+			return "", err
+		}
+
+		return fullMethod + "|" + key, nil
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/hizumisen/go-rate-limiter/httplimit
+
+go 1.21.6
+
+replace github.com/hizumisen/go-rate-limiter/core => ../core
+
+require github.com/hizumisen/go-rate-limiter/core v0.0.0-00010101000000-000000000000
@@ -0,0 +1,80 @@
+package httplimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrMissingKey = errors.New("missing rate limit key")
+
+type KeyFunc func(r *http.Request) (string, error)
+
+type CostFunc func(r *http.Request) float64
+
+func ClientIP(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("can't parse remote address %s: %w", r.RemoteAddr, err)
+	}
+
+	return host, nil
+}
+
+func Header(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", fmt.Errorf("can't find header %s: %w", name, ErrMissingKey)
+		}
+
+		return value, nil
+	}
+}
+
+// APIKey reads the bearer token of the Authorization header or the X-API-Key
+// header, the key is hashed so that it isn't written to the store
+func APIKey(r *http.Request) (string, error) {
+	apiKey, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		apiKey = r.Header.Get("X-API-Key")
+	}
+
+	if apiKey == "" {
+		return "", fmt.Errorf("can't find the api key: %w", ErrMissingKey)
+	}
+
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func Route(r *http.Request) (string, error) {
+	return r.Method + " " + r.URL.Path, nil
+}
+
+// RouteAndUser limits every user on every route separately
+func RouteAndUser(user KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		route, err := Route(r)
+		if err != nil {
+			return "", err
+		}
+
+		userKey, err := user(r)
+		if err != nil {
+			return "", err
+		}
+
+		return route + "|" + userKey, nil
+	}
+}
+
+func ConstantCost(tokens float64) CostFunc {
+	return func(r *http.Request) float64 {
+		return tokens
+	}
+}
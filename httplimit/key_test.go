@@ -0,0 +1,55 @@
+package httplimit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hizumisen/go-rate-limiter/httplimit"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func TestClientIP(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	request.RemoteAddr = "10.0.0.1:4321"
+
+	key, err := httplimit.ClientIP(request)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, "10.0.0.1", key)
+}
+
+func TestAPIKey_SameKeyFromBothHeaders(t *testing.T) {
+	bearer := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	bearer.Header.Set("Authorization", "Bearer secret")
+	header := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	header.Header.Set("X-API-Key", "secret")
+
+	bearerKey, err := httplimit.APIKey(bearer)
+	testutils.RequireNoError(t, err)
+	headerKey, err := httplimit.APIKey(header)
+	testutils.RequireNoError(t, err)
+
+	testutils.RequireEqual(t, bearerKey, headerKey)
+	if bearerKey == "secret" {
+		t.Errorf("expected the api key to be hashed")
+	}
+}
+
+func TestAPIKey_Missing(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+
+	_, err := httplimit.APIKey(request)
+	if !errors.Is(err, httplimit.ErrMissingKey) {
+		t.Errorf("expected ErrMissingKey, got %v", err)
+	}
+}
+
+func TestRouteAndUser(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	request.Header.Set("X-User", "user1")
+
+	key, err := httplimit.RouteAndUser(httplimit.Header("X-User"))(request)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, "POST /orders|user1", key)
+}
@@ -0,0 +1,128 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+)
+
+type Limiter interface {
+	Reserve(ctx context.Context, key string, tokens float64) error
+}
+
+// StatusLimiter is implemented by the limiters that can fill the
+// RateLimit-Limit and RateLimit-Remaining headers, as core.RateLimiter does
+type StatusLimiter interface {
+	Status(ctx context.Context, key string, tokens float64) (core.Status, error)
+}
+
+var _ StatusLimiter = core.RateLimiter[*core.TokenBucket]{}
+
+type Middleware struct {
+	limiter  Limiter
+	keyFunc  KeyFunc
+	costFunc CostFunc
+	failOpen bool
+	logger   *slog.Logger
+}
+
+func NewMiddleware(
+	logger *slog.Logger,
+	limiter Limiter,
+	keyFunc KeyFunc,
+) Middleware {
+	return Middleware{
+		limiter:  limiter,
+		keyFunc:  keyFunc,
+		costFunc: ConstantCost(1),
+		logger:   logger,
+	}
+}
+
+func (m Middleware) WithCost(costFunc CostFunc) Middleware {
+	m.costFunc = costFunc
+	return m
+}
+
+// WithFailOpen lets the requests through when the limiter fails, by default
+// they are rejected with 503
+func (m Middleware) WithFailOpen(failOpen bool) Middleware {
+	m.failOpen = failOpen
+	return m
+}
+
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := m.keyFunc(r)
+		if err != nil {
+			m.logger.Debug("can't extract the rate limit key", "error", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		cost := m.costFunc(r)
+
+		err = m.limiter.Reserve(r.Context(), key, cost)
+
+		var tooManyReqErr core.ErrTooManyRequests
+		if errors.As(err, &tooManyReqErr) {
+			m.writeTooManyRequests(w, r, key, cost, tooManyReqErr)
+			return
+		}
+
+		//the request costs more than the limit, it would never pass
+		if errors.Is(err, core.ErrOutOfBoundsRequest) {
+			m.logger.Debug("rate limit cost out of bounds", "key", key, "cost", cost, "error", err)
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err != nil {
+			m.logger.Warn("can't reserve rate limit tokens", "key", key, "error", err, "failOpen", m.failOpen)
+			if !m.failOpen {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m Middleware) writeTooManyRequests(
+	w http.ResponseWriter,
+	r *http.Request,
+	key string,
+	cost float64,
+	tooManyReqErr core.ErrTooManyRequests,
+) {
+	retryAfter := formatSeconds(tooManyReqErr.RetryAfter)
+	w.Header().Set("Retry-After", retryAfter)
+	w.Header().Set("RateLimit-Reset", retryAfter)
+
+	statusLimiter, ok := m.limiter.(StatusLimiter)
+	if ok {
+		status, err := statusLimiter.Status(r.Context(), key, cost)
+		if err == nil {
+			w.Header().Set("RateLimit-Limit", strconv.FormatFloat(math.Floor(status.Limit), 'f', -1, 64))
+			w.Header().Set("RateLimit-Remaining", strconv.FormatFloat(math.Max(math.Floor(status.Remaining), 0), 'f', -1, 64))
+			w.Header().Set("RateLimit-Reset", formatSeconds(time.Until(status.ResetAt)))
+		} else if !errors.Is(err, core.ErrNotSupported) {
+			m.logger.Warn("can't get rate limit status", "key", key, "error", err)
+		}
+	}
+
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// the headers carry whole seconds, rounded up so that the client doesn't retry too early
+func formatSeconds(duration time.Duration) string {
+	seconds := math.Max(math.Ceil(duration.Seconds()), 0)
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
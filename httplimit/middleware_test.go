@@ -0,0 +1,126 @@
+package httplimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/httplimit"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+type failingLimiter struct{}
+
+func (failingLimiter) Reserve(ctx context.Context, key string, tokens float64) error {
+	return errors.New("store unavailable")
+}
+
+func buildHandler(limiter httplimit.Limiter, failOpen bool) http.Handler {
+	middleware := httplimit.NewMiddleware(testutils.NewNoOpLogger(), limiter, httplimit.Header("X-User")).
+		WithFailOpen(failOpen)
+
+	return middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func serve(handler http.Handler, user string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	if user != "" {
+		request.Header.Set("X-User", user)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestMiddleware_Handler_TooManyRequests(t *testing.T) {
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.1)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+	handler := buildHandler(rateLimiter, false)
+
+	testutils.RequireEqual(t, http.StatusNoContent, serve(handler, "user1").Code)
+	testutils.RequireEqual(t, http.StatusNoContent, serve(handler, "user1").Code)
+
+	response := serve(handler, "user1")
+	testutils.RequireEqual(t, http.StatusTooManyRequests, response.Code)
+	testutils.RequireEqual(t, "10", response.Header().Get("Retry-After"))
+	testutils.RequireEqual(t, "2", response.Header().Get("RateLimit-Limit"))
+	testutils.RequireEqual(t, "0", response.Header().Get("RateLimit-Remaining"))
+	testutils.RequireEqual(t, "20", response.Header().Get("RateLimit-Reset"))
+
+	//the other keys have their own bucket
+	testutils.RequireEqual(t, http.StatusNoContent, serve(handler, "user2").Code)
+}
+
+func TestMiddleware_Handler_MissingKey(t *testing.T) {
+	handler := buildHandler(failingLimiter{}, true)
+	testutils.RequireEqual(t, http.StatusBadRequest, serve(handler, "").Code)
+}
+
+func TestMiddleware_Handler_FailOpen(t *testing.T) {
+	handler := buildHandler(failingLimiter{}, true)
+	testutils.RequireEqual(t, http.StatusNoContent, serve(handler, "user1").Code)
+}
+
+func TestMiddleware_Handler_FailClosed(t *testing.T) {
+	handler := buildHandler(failingLimiter{}, false)
+	testutils.RequireEqual(t, http.StatusServiceUnavailable, serve(handler, "user1").Code)
+}
+
+type overdrawnLimiter struct{}
+
+func (overdrawnLimiter) Reserve(ctx context.Context, key string, tokens float64) error {
+	return core.ErrTooManyRequests{RetryAfter: time.Second}
+}
+
+func (overdrawnLimiter) Status(ctx context.Context, key string, tokens float64) (core.Status, error) {
+	return core.Status{Limit: 2, Remaining: -3.5, ResetAt: time.Now().Add(time.Second)}, nil
+}
+
+func TestMiddleware_Handler_RemainingNotNegative(t *testing.T) {
+	response := serve(buildHandler(overdrawnLimiter{}, false), "user1")
+	testutils.RequireEqual(t, http.StatusTooManyRequests, response.Code)
+	testutils.RequireEqual(t, "0", response.Header().Get("RateLimit-Remaining"))
+}
+
+func TestMiddleware_Handler_OutOfBoundsCost(t *testing.T) {
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.1)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+
+	//a cost over the limit is a client error, even when failing open
+	middleware := httplimit.NewMiddleware(testutils.NewNoOpLogger(), rateLimiter, httplimit.Route).
+		WithCost(httplimit.ConstantCost(3)).
+		WithFailOpen(true)
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testutils.RequireEqual(t, http.StatusRequestEntityTooLarge, serve(handler, "").Code)
+}
+
+func TestMiddleware_Handler_Cost(t *testing.T) {
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(5, 0.1)
+		},
+		core.NewInMemoryStore[*core.TokenBucket](10),
+	)
+	middleware := httplimit.NewMiddleware(testutils.NewNoOpLogger(), rateLimiter, httplimit.Route).
+		WithCost(httplimit.ConstantCost(3))
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testutils.RequireEqual(t, http.StatusOK, serve(handler, "").Code)
+	testutils.RequireEqual(t, http.StatusTooManyRequests, serve(handler, "").Code)
+}
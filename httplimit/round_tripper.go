@@ -0,0 +1,121 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+)
+
+type Waiter interface {
+	Wait(ctx context.Context, key string, tokens float64) error
+}
+
+// Drainer is implemented by the limiters that can hold back the key until the
+// Retry-After of an upstream 429, as core.RateLimiter does
+type Drainer interface {
+	Drain(ctx context.Context, key string, retryAfter time.Duration) error
+}
+
+var _ Drainer = core.RateLimiter[*core.TokenBucket]{}
+
+type RoundTripper struct {
+	next        http.RoundTripper
+	limiter     Waiter
+	keyFunc     KeyFunc
+	costFunc    CostFunc
+	nowProvider func() time.Time
+}
+
+var _ http.RoundTripper = &RoundTripper{}
+
+func NewRoundTripper(
+	next http.RoundTripper,
+	limiter Waiter,
+	keyFunc KeyFunc,
+) *RoundTripper {
+	return &RoundTripper{
+		next:        next,
+		limiter:     limiter,
+		keyFunc:     keyFunc,
+		costFunc:    ConstantCost(1),
+		nowProvider: time.Now,
+	}
+}
+
+func (rt *RoundTripper) WithCost(costFunc CostFunc) *RoundTripper {
+	rt.costFunc = costFunc
+	return rt
+}
+
+func Host(r *http.Request) (string, error) {
+	return r.URL.Host, nil
+}
+
+func (rt *RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	key, err := rt.keyFunc(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't extract the rate limit key: %w", err)
+	}
+
+	err = rt.limiter.Wait(r.Context(), key, rt.costFunc(r))
+	if err != nil {
+		return nil, fmt.Errorf("can't wait for rate limit capacity: %w", err)
+	}
+
+	response, err := rt.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		err = rt.drain(r.Context(), key, response.Header.Get("Retry-After"))
+		if err != nil {
+			response.Body.Close()
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// drain spends the tokens of the key through the limiter, so the replicas
+// sharing its store wait for the Retry-After too
+func (rt *RoundTripper) drain(ctx context.Context, key string, retryAfterHeader string) error {
+	drainer, ok := rt.limiter.(Drainer)
+	if !ok {
+		return nil
+	}
+
+	retryAfter, ok := rt.parseRetryAfter(retryAfterHeader)
+	if !ok {
+		return nil
+	}
+
+	err := drainer.Drain(ctx, key, retryAfter)
+	if err != nil && !errors.Is(err, core.ErrNotSupported) {
+		return fmt.Errorf("can't drain the rate limit after the upstream 429: %w", err)
+	}
+
+	return nil
+}
+
+// Retry-After is either a number of seconds or an http date
+func (rt *RoundTripper) parseRetryAfter(value string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return time.Duration(seconds) * time.Second, seconds > 0
+	}
+
+	at, err := http.ParseTime(value)
+	if err == nil {
+		retryAfter := at.Sub(rt.nowProvider())
+		return retryAfter, retryAfter > 0
+	}
+
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package httplimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/httplimit"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+)
+
+func buildClient(maxTokens, refillRate float64) *http.Client {
+	return buildClientWithStore(maxTokens, refillRate, core.NewInMemoryStore[*core.TokenBucket](10))
+}
+
+func buildClientWithStore(maxTokens, refillRate float64, store core.AlgorithmStorer[*core.TokenBucket]) *http.Client {
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(maxTokens, refillRate)
+		},
+		store,
+	)
+
+	return &http.Client{
+		Transport: httplimit.NewRoundTripper(http.DefaultTransport, rateLimiter, httplimit.Host),
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) int {
+	t.Helper()
+
+	response, err := client.Get(url)
+	testutils.RequireNoError(t, err)
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+func TestRoundTripper_RoundTrip_WaitForCapacity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := buildClient(1, 10)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		testutils.RequireEqual(t, http.StatusOK, get(t, client, server.URL))
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("expected to wait for the tokens, waited %s", elapsed)
+	}
+}
+
+func TestRoundTripper_RoundTrip_PauseOnUpstreamTooManyRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+	client := buildClient(10, 10)
+
+	testutils.RequireEqual(t, http.StatusTooManyRequests, get(t, client, server.URL))
+
+	start := time.Now()
+	testutils.RequireEqual(t, http.StatusOK, get(t, client, server.URL))
+
+	elapsed := time.Since(start)
+	if elapsed < 900*time.Millisecond {
+		t.Errorf("expected to pause after the upstream 429, paused %s", elapsed)
+	}
+}
+
+func TestRoundTripper_RoundTrip_ShareUpstreamTooManyRequestsThroughTheStore(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	//two replicas sharing the same store
+	store := core.NewInMemoryStore[*core.TokenBucket](10)
+	client := buildClientWithStore(10, 10, store)
+	otherClient := buildClientWithStore(10, 10, store)
+
+	testutils.RequireEqual(t, http.StatusTooManyRequests, get(t, client, server.URL))
+
+	start := time.Now()
+	testutils.RequireEqual(t, http.StatusOK, get(t, otherClient, server.URL))
+
+	elapsed := time.Since(start)
+	if elapsed < 900*time.Millisecond {
+		t.Errorf("expected the other replica to pause after the upstream 429, paused %s", elapsed)
+	}
+}
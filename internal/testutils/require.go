@@ -2,6 +2,7 @@ package testutils
 
 import (
 	"cmp"
+	"errors"
 	"slices"
 	"testing"
 )
@@ -35,3 +36,14 @@ func RequireElementsMatch[T cmp.Ordered](t *testing.T, expected []T, actual []T)
 		t.Errorf("expected same elements:\nexpected=%v\nactual=%v\n", expected, actual)
 	}
 }
+
+func RequireErrorAs[E error](t *testing.T, err error) E {
+	t.Helper()
+
+	var target E
+	if !errors.As(err, &target) {
+		t.Fatalf("expected error of type %T, got %v", target, err)
+	}
+
+	return target
+}
@@ -11,3 +11,19 @@ func NowProvider(t time.Time) func() time.Time {
 func NewTimeAt(hour int) time.Time {
 	return time.Date(2000, 1, 1, hour, 0, 0, 0, time.UTC)
 }
+
+type Clock struct {
+	now time.Time
+}
+
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+func (c *Clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,212 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type TokenBucketStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewTokenBucketStore(
+	client redis.UniversalClient,
+	prefix string,
+) *TokenBucketStore {
+	return &TokenBucketStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+var _ core.AlgorithmStorer[*core.TokenBucket] = &TokenBucketStore{}
+var _ core.ReserveStorer[*core.TokenBucket] = &TokenBucketStore{}
+
+const (
+	tokensKey         = "tokens"
+	maxTokensKey      = "maxTokens"
+	refillRateKey     = "refillRate"
+	lastRefillTimeKey = "lastRefillTime"
+	expireAtKey       = "expireAt"
+)
+
+// times are stored as unix microseconds, expireAt is used to order the writes
+// as the sort value does for the other storers
+var storeScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'expireAt')
+if current and tonumber(current) >= tonumber(ARGV[5]) then
+	return redis.call('HMGET', KEYS[1], 'tokens', 'maxTokens', 'refillRate', 'lastRefillTime')
+end
+
+redis.call('HSET', KEYS[1],
+	'tokens', ARGV[1],
+	'maxTokens', ARGV[2],
+	'refillRate', ARGV[3],
+	'lastRefillTime', ARGV[4],
+	'expireAt', ARGV[5])
+redis.call('PEXPIREAT', KEYS[1], math.ceil(tonumber(ARGV[5]) / 1000) + 1)
+
+return {ARGV[1], ARGV[2], ARGV[3], ARGV[4]}
+`)
+
+// the bucket is refilled with the redis server clock, the bucket parameters
+// always come from the caller so that they can be changed
+var reserveScript = redis.NewScript(`
+local requested = tonumber(ARGV[1])
+local maxTokens = tonumber(ARGV[2])
+local refillRate = tonumber(ARGV[3])
+
+if requested > maxTokens then
+	return {-1, 0}
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'lastRefillTime')
+local tokens = maxTokens
+local lastRefillTime = now
+if data[1] then
+	tokens = tonumber(data[1])
+	lastRefillTime = tonumber(data[2])
+end
+
+if now > lastRefillTime then
+	tokens = math.min(tokens + refillRate * (now - lastRefillTime) / 1000000, maxTokens)
+	lastRefillTime = now
+end
+
+if requested > tokens then
+	return {0, math.ceil((requested - tokens) / refillRate * 1000000)}
+end
+
+tokens = tokens - requested
+local expireAt = lastRefillTime + math.ceil((maxTokens - tokens) / refillRate * 1000000)
+
+redis.call('HSET', KEYS[1],
+	'tokens', tostring(tokens),
+	'maxTokens', ARGV[2],
+	'refillRate', ARGV[3],
+	'lastRefillTime', string.format('%d', lastRefillTime),
+	'expireAt', string.format('%d', expireAt))
+redis.call('PEXPIREAT', KEYS[1], math.ceil(expireAt / 1000) + 1)
+
+return {1, 0}
+`)
+
+func (store *TokenBucketStore) key(key string) string {
+	return store.prefix + key
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func decodeTokenBucket(values []interface{}) (*core.TokenBucket, error) {
+	if len(values) != 4 || values[0] == nil {
+		return nil, nil
+	}
+
+	var fields [4]float64
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected redis value %v", value)
+		}
+
+		field, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse redis value %s: %w", str, err)
+		}
+
+		fields[i] = field
+	}
+
+	return &core.TokenBucket{
+		Tokens:         fields[0],
+		MaxTokens:      fields[1],
+		RefillRate:     fields[2],
+		LastRefillTime: time.UnixMicro(int64(fields[3])),
+	}, nil
+}
+
+func (store *TokenBucketStore) Store(
+	ctx context.Context,
+	key string,
+	alg *core.TokenBucket,
+) (*core.TokenBucket, error) {
+	result, err := storeScript.Run(
+		ctx,
+		store.client,
+		[]string{store.key(key)},
+		formatFloat(alg.Tokens),
+		formatFloat(alg.MaxTokens),
+		formatFloat(alg.RefillRate),
+		alg.LastRefillTime.UnixMicro(),
+		alg.ExpireAt().UnixMicro(),
+	).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("can't store token bucket into redis: %w", err)
+	}
+
+	stored, err := decodeTokenBucket(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (store *TokenBucketStore) Load(ctx context.Context, key string) (**core.TokenBucket, error) {
+	result, err := store.client.HMGet(
+		ctx,
+		store.key(key),
+		tokensKey, maxTokensKey, refillRateKey, lastRefillTimeKey,
+	).Result()
+	if err != nil {
+		return nil, fmt.Errorf("can't get token bucket from redis: %w", err)
+	}
+
+	alg, err := decodeTokenBucket(result)
+	if err != nil || alg == nil {
+		return nil, err
+	}
+
+	return &alg, nil
+}
+
+func (store *TokenBucketStore) Reserve(
+	ctx context.Context,
+	key string,
+	alg *core.TokenBucket,
+	tokens float64,
+) error {
+	result, err := reserveScript.Run(
+		ctx,
+		store.client,
+		[]string{store.key(key)},
+		formatFloat(tokens),
+		formatFloat(alg.MaxTokens),
+		formatFloat(alg.RefillRate),
+	).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("can't reserve token bucket on redis: %w", err)
+	}
+
+	switch result[0] {
+	case -1:
+		return fmt.Errorf("can't reserve more than %f tokens:%w", alg.MaxTokens, core.ErrOutOfBoundsRequest)
+	case 0:
+		retryAfter := time.Duration(result[1]) * time.Microsecond
+		return core.ErrTooManyRequests{RetryAfter: retryAfter}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+	"github.com/hizumisen/go-rate-limiter/redis"
+)
+
+func buildStore(t *testing.T) (*redis.TokenBucketStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return redis.NewTokenBucketStore(client, "rate-limit:"), server
+}
+
+func TestTokenBucketStore_Store_NewAlg(t *testing.T) {
+	ctx := context.Background()
+	store, _ := buildStore(t)
+
+	alg := core.NewTokenBucket(10, 1)
+	alg.Tokens = 5
+	got, err := store.Store(ctx, "key1", alg)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 5.0, got.Tokens)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 5.0, (*loaded).Tokens)
+	testutils.RequireEqual(t, 10.0, (*loaded).MaxTokens)
+	testutils.RequireEqual(t, 1.0, (*loaded).RefillRate)
+	testutils.RequireEqual(t, alg.LastRefillTime.UnixMicro(), (*loaded).LastRefillTime.UnixMicro())
+}
+
+func TestTokenBucketStore_Store_NotOverrideAlgIfExpireAtIsLesserAndReturnStored(t *testing.T) {
+	ctx := context.Background()
+	store, _ := buildStore(t)
+
+	alg1 := core.NewTokenBucket(10, 1).WitNowProvider(testutils.NowProvider(time.Now()))
+	alg1.Tokens = 2
+	_, err := store.Store(ctx, "key1", alg1)
+	testutils.RequireNoError(t, err)
+
+	alg2 := core.NewTokenBucket(10, 1).WitNowProvider(testutils.NowProvider(time.Now()))
+	alg2.Tokens = 8
+	got, err := store.Store(ctx, "key1", alg2)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, 2.0, got.Tokens)
+}
+
+func TestTokenBucketStore_Load_MissingKey(t *testing.T) {
+	ctx := context.Background()
+	store, _ := buildStore(t)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	if loaded != nil {
+		t.Errorf("expected missing key, got %v", *loaded)
+	}
+}
+
+func TestTokenBucketStore_Reserve_RejectWhenEmpty(t *testing.T) {
+	ctx := context.Background()
+	store, server := buildStore(t)
+	server.SetTime(testutils.NewTimeAt(1))
+
+	for i := 0; i < 5; i++ {
+		err := store.Reserve(ctx, "key1", core.NewTokenBucket(5, 2), 1)
+		testutils.RequireNoError(t, err)
+	}
+
+	err := store.Reserve(ctx, "key1", core.NewTokenBucket(5, 2), 1)
+	var tooManyReqErr core.ErrTooManyRequests
+	if !errors.As(err, &tooManyReqErr) {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+	testutils.RequireEqual(t, 500*time.Millisecond, tooManyReqErr.RetryAfter)
+
+	server.SetTime(testutils.NewTimeAt(1).Add(tooManyReqErr.RetryAfter))
+	err = store.Reserve(ctx, "key1", core.NewTokenBucket(5, 2), 1)
+	testutils.RequireNoError(t, err)
+}
+
+func TestTokenBucketStore_Reserve_OutOfBounds(t *testing.T) {
+	ctx := context.Background()
+	store, _ := buildStore(t)
+
+	err := store.Reserve(ctx, "key1", core.NewTokenBucket(5, 2), 6)
+	if !errors.Is(err, core.ErrOutOfBoundsRequest) {
+		t.Fatalf("expected ErrOutOfBoundsRequest, got %v", err)
+	}
+}
+
+func TestTokenBucketStore_Reserve_ExpireWhenFull(t *testing.T) {
+	ctx := context.Background()
+	store, server := buildStore(t)
+	server.SetTime(testutils.NewTimeAt(1))
+
+	err := store.Reserve(ctx, "key1", core.NewTokenBucket(5, 2), 2)
+	testutils.RequireNoError(t, err)
+
+	ttl := server.TTL("rate-limit:key1")
+	if ttl <= 0 || ttl > 2*time.Second {
+		t.Errorf("expected to expire once full, ttl=%s", ttl)
+	}
+}
+
+func TestTokenBucketStore_RateLimiter_ReserveOnServer(t *testing.T) {
+	ctx := context.Background()
+	store, _ := buildStore(t)
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001)
+		},
+		store,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	var tooManyReqErr core.ErrTooManyRequests
+	err := rateLimiter.Reserve(ctx, "key1", 1)
+	if !errors.As(err, &tooManyReqErr) {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+}
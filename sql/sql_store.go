@@ -0,0 +1,199 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+)
+
+type SqlStore[T core.Algorithm] struct {
+	db          *sql.DB
+	tableName   string
+	nowProvider func() time.Time
+}
+
+func NewSqlStore[T core.Algorithm](
+	db *sql.DB,
+	tableName string,
+) *SqlStore[T] {
+	return &SqlStore[T]{
+		db:          db,
+		tableName:   tableName,
+		nowProvider: time.Now,
+	}
+}
+
+var _ core.AlgorithmStorer[*core.TokenBucket] = &SqlStore[*core.TokenBucket]{}
+
+// implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// isSqlite tells SQLite apart, it's the only database with sqlite_version()
+func isSqlite(ctx context.Context, db *sql.DB) bool {
+	var version string
+	return db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version) == nil
+}
+
+// CreateTableIfMissing creates the table used by SqlStore, the statements
+// work with both PostgreSQL and SQLite
+func CreateTableIfMissing(ctx context.Context, db *sql.DB, tableName string) error {
+	//the sort values are compared byte by byte, as SQLite does by default,
+	//PostgreSQL would use the database collation otherwise
+	collation := ` COLLATE "C"`
+	if isSqlite(ctx, db) {
+		collation = ""
+	}
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		rate_key TEXT PRIMARY KEY,
+		alg TEXT NOT NULL,
+		sort TEXT%s NOT NULL,
+		expire_at BIGINT NOT NULL
+	)`, tableName, collation))
+	if err != nil {
+		return fmt.Errorf("can't create table: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %s_expire_at_idx ON %s (expire_at)",
+		tableName, tableName,
+	))
+	if err != nil {
+		return fmt.Errorf("can't create expire_at index: %w", err)
+	}
+
+	return nil
+}
+
+func (store *SqlStore[T]) decodeAlg(data string) (T, error) {
+	var alg T
+
+	err := json.Unmarshal([]byte(data), &alg)
+	if err != nil {
+		return alg, fmt.Errorf("can't unmarshall sql row to go object: %w", err)
+	}
+
+	return alg, nil
+}
+
+func (store *SqlStore[T]) loadAlg(ctx context.Context, querier querier, key string) (*T, error) {
+	var data string
+
+	err := querier.QueryRowContext(
+		ctx,
+		fmt.Sprintf("SELECT alg FROM %s WHERE rate_key = $1", store.tableName),
+		key,
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't select row: %w", err)
+	}
+
+	alg, err := store.decodeAlg(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &alg, nil
+}
+
+func (store *SqlStore[T]) Store(ctx context.Context, key string, alg T) (T, error) {
+	var defaultVal T
+
+	data, err := json.Marshal(alg)
+	if err != nil {
+		return defaultVal, fmt.Errorf("can't marshall `alg` into sql row: %w", err)
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return defaultVal, fmt.Errorf("can't begin transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	//the row is updated only if the sort value increases
+	_, err = tx.ExecContext(
+		ctx,
+		fmt.Sprintf(`INSERT INTO %s (rate_key, alg, sort, expire_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (rate_key) DO UPDATE
+			SET alg = excluded.alg, sort = excluded.sort, expire_at = excluded.expire_at
+			WHERE %s.sort < excluded.sort`,
+			store.tableName, store.tableName,
+		),
+		key, string(data), alg.SortValue(), alg.ExpireAt().UnixMilli(),
+	)
+	if err != nil {
+		return defaultVal, fmt.Errorf("can't upsert row: %w", err)
+	}
+
+	stored, err := store.loadAlg(ctx, tx, key)
+	if err != nil {
+		return defaultVal, err
+	}
+
+	if stored == nil {
+		return defaultVal, fmt.Errorf("can't find the upserted row for key %s", key)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return defaultVal, fmt.Errorf("can't commit transaction: %w", err)
+	}
+
+	return *stored, nil
+}
+
+func (store *SqlStore[T]) Load(ctx context.Context, key string) (*T, error) {
+	return store.loadAlg(ctx, store.db, key)
+}
+
+func (store *SqlStore[T]) Purge(ctx context.Context) (int64, error) {
+	result, err := store.db.ExecContext(
+		ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE expire_at < $1", store.tableName),
+		store.nowProvider().UnixMilli(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("can't delete expired rows: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("can't count deleted rows: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// RunPurge deletes the expired rows every interval until ctx is done
+func (store *SqlStore[T]) RunPurge(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := store.Purge(ctx)
+			if err != nil {
+				logger.Warn("can't purge expired rows", "error", err)
+				continue
+			}
+
+			logger.Debug("purged expired rows", "deleted", deleted)
+		}
+	}
+}
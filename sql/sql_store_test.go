@@ -0,0 +1,150 @@
+package sql_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/hizumisen/go-rate-limiter/core"
+	"github.com/hizumisen/go-rate-limiter/internal/testutils"
+	rateSql "github.com/hizumisen/go-rate-limiter/sql"
+)
+
+type storedItem struct {
+	At time.Time
+}
+
+func newStoredItemAtHour(hour int) storedItem {
+	return storedItem{At: time.Date(3000, 1, 1, hour, 0, 0, 0, time.UTC)}
+}
+
+func (s storedItem) Reserve(tokens float64) error {
+	return nil
+}
+
+func (s storedItem) SortValue() string {
+	return fmt.Sprintf("%v", s.At)
+}
+
+func (s storedItem) ExpireAt() time.Time {
+	return s.At
+}
+
+func buildDb(ctx context.Context, t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "rate-limit.db"))
+	testutils.RequireNoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	err = rateSql.CreateTableIfMissing(ctx, db, "rate_limit")
+	testutils.RequireNoError(t, err)
+
+	return db
+}
+
+func TestSqlStore_Store_NewAlg(t *testing.T) {
+	ctx := context.Background()
+	store := rateSql.NewSqlStore[storedItem](buildDb(ctx, t), "rate_limit")
+
+	got, err := store.Store(ctx, "key1", newStoredItemAtHour(1))
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), got)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), *loaded)
+}
+
+func TestSqlStore_Store_OverrideAlgIfSortIsGreater(t *testing.T) {
+	ctx := context.Background()
+	store := rateSql.NewSqlStore[storedItem](buildDb(ctx, t), "rate_limit")
+
+	got, err := store.Store(ctx, "key1", newStoredItemAtHour(1))
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), got)
+
+	got, err = store.Store(ctx, "key1", newStoredItemAtHour(2))
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(2), got)
+}
+
+func TestSqlStore_Store_NotOverrideAlgIfSortIsLesserAndReturnStored(t *testing.T) {
+	ctx := context.Background()
+	store := rateSql.NewSqlStore[storedItem](buildDb(ctx, t), "rate_limit")
+
+	got, err := store.Store(ctx, "key1", newStoredItemAtHour(2))
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(2), got)
+
+	got, err = store.Store(ctx, "key1", newStoredItemAtHour(1))
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(2), got)
+}
+
+func TestSqlStore_Load_MissingKey(t *testing.T) {
+	ctx := context.Background()
+	store := rateSql.NewSqlStore[storedItem](buildDb(ctx, t), "rate_limit")
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	if loaded != nil {
+		t.Errorf("expected missing key, got %v", *loaded)
+	}
+}
+
+func TestSqlStore_Purge_DeleteExpiredRows(t *testing.T) {
+	ctx := context.Background()
+	store := rateSql.NewSqlStore[storedItem](buildDb(ctx, t), "rate_limit")
+
+	expired := storedItem{At: time.Now().Add(-time.Hour)}
+	_, err := store.Store(ctx, "key1", expired)
+	testutils.RequireNoError(t, err)
+	_, err = store.Store(ctx, "key2", newStoredItemAtHour(1))
+	testutils.RequireNoError(t, err)
+
+	deleted, err := store.Purge(ctx)
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, int64(1), deleted)
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	if loaded != nil {
+		t.Errorf("expected purged key, got %v", *loaded)
+	}
+
+	loaded, err = store.Load(ctx, "key2")
+	testutils.RequireNoError(t, err)
+	testutils.RequireEqual(t, newStoredItemAtHour(1), *loaded)
+}
+
+func TestSqlStore_RateLimiter_TokenBucket(t *testing.T) {
+	ctx := context.Background()
+	store := rateSql.NewSqlStore[*core.TokenBucket](buildDb(ctx, t), "rate_limit")
+
+	rateLimiter := core.NewRateLimiter(
+		func() *core.TokenBucket {
+			return core.NewTokenBucket(2, 0.001)
+		},
+		store,
+	)
+
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+	testutils.RequireNoError(t, rateLimiter.Reserve(ctx, "key1", 1))
+
+	err := rateLimiter.Reserve(ctx, "key1", 1)
+	if err == nil {
+		t.Fatalf("expected ErrTooManyRequests")
+	}
+
+	loaded, err := store.Load(ctx, "key1")
+	testutils.RequireNoError(t, err)
+	if (*loaded).Tokens >= 1 {
+		t.Errorf("expected the bucket to be empty, got %f tokens", (*loaded).Tokens)
+	}
+}